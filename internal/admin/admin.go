@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// StatusFunc returns a JSON serializable snapshot of a component's state.
+type StatusFunc func() any
+
+// NewHandler serves the state of the registered components as a single JSON
+// document on /status, keyed by component name.
+func NewHandler(components map[string]StatusFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := make(map[string]any, len(components))
+		for name, fn := range components {
+			status[name] = fn()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	return mux
+}
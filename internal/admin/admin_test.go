@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	handler := NewHandler(map[string]StatusFunc{
+		"provider": func() any { return map[string]int{"pendingFetches": 3} },
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"provider":{"pendingFetches":3}}`, rec.Body.String())
+}
+
+func TestStatus_MethodNotAllowed(t *testing.T) {
+	handler := NewHandler(nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Key identifies a credential of a specific Credential Store instance.
+type Key struct {
+	ServiceKey string
+	Namespace  string
+	Type       string
+	Name       string
+}
+
+type Options struct {
+	// TTL is the duration for which a fetched credential is served without contacting Credential Store.
+	TTL time.Duration
+	// MaxSize is the maximum number of cached credentials, the least recently used ones are evicted first.
+	MaxSize int
+	// MaxStale is the maximum age of a credential which is served when Credential Store cannot be reached.
+	// Values below TTL disable serving stale credentials.
+	MaxStale time.Duration
+}
+
+type FetchFunc func(ctx context.Context) (map[string]string, error)
+
+// Cache is a size bounded in-memory cache of credential fields.
+type Cache struct {
+	opts    Options
+	mu      sync.Mutex
+	entries map[Key]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+type entry struct {
+	key       Key
+	fields    map[string]string
+	fetchedAt time.Time
+}
+
+func New(opts Options) *Cache {
+	return &Cache{
+		opts:    opts,
+		entries: make(map[Key]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns the credential fields for key, calling fetch when there is no fresh entry.
+// If fetch fails and the cached entry is younger than MaxStale, the entry is returned
+// together with the fetch error, which the caller may log or propagate.
+func (c *Cache) Get(ctx context.Context, key Key, fetch FetchFunc) (fields map[string]string, stale bool, err error) {
+	cached, age, ok := c.lookup(key)
+	if ok && age < c.opts.TTL {
+		return cached, false, nil
+	}
+
+	fields, err = fetch(ctx)
+	if err == nil {
+		c.store(key, fields)
+		return fields, false, nil
+	}
+
+	if ok && age < c.opts.MaxStale {
+		return cached, true, err
+	}
+
+	return nil, false, err
+}
+
+// Len returns the number of cached credentials.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache) lookup(key Key) (map[string]string, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	e := elem.Value.(*entry)
+	age := c.now().Sub(e.fetchedAt)
+	if age >= c.opts.TTL && age >= c.opts.MaxStale {
+		c.remove(elem)
+		return nil, 0, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return e.fields, age, true
+}
+
+func (c *Cache) store(key Key, fields map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		e.fields = fields
+		e.fetchedAt = c.now()
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key: key, fields: fields, fetchedAt: c.now()})
+	for c.opts.MaxSize > 0 && c.lru.Len() > c.opts.MaxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
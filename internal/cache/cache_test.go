@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key         = Key{ServiceKey: "sk", Namespace: "dev", Type: "password", Name: "myPassword"}
+	errUpstream = errors.New("credstore unavailable")
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(opts Options) (*Cache, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := New(opts)
+	c.now = clock.Now
+	return c, clock
+}
+
+func fetchValue(value string, calls *int) FetchFunc {
+	return func(ctx context.Context) (map[string]string, error) {
+		*calls++
+		return map[string]string{"value": value}, nil
+	}
+}
+
+func fetchError(calls *int) FetchFunc {
+	return func(ctx context.Context) (map[string]string, error) {
+		*calls++
+		return nil, errUpstream
+	}
+}
+
+func TestGet_ServesFreshEntries(t *testing.T) {
+	c, clock := newTestCache(Options{TTL: time.Minute})
+	calls := 0
+
+	fields, stale, err := c.Get(context.Background(), key, fetchValue("v1", &calls))
+	require.NoError(t, err)
+	require.False(t, stale)
+	require.Equal(t, "v1", fields["value"])
+
+	clock.Advance(30 * time.Second)
+	fields, _, err = c.Get(context.Background(), key, fetchValue("v2", &calls))
+	require.NoError(t, err)
+	require.Equal(t, "v1", fields["value"])
+	require.Equal(t, 1, calls)
+
+	clock.Advance(30 * time.Second)
+	fields, _, err = c.Get(context.Background(), key, fetchValue("v2", &calls))
+	require.NoError(t, err)
+	require.Equal(t, "v2", fields["value"])
+	require.Equal(t, 2, calls)
+}
+
+func TestGet_KeysIncludeServiceKey(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute})
+	calls := 0
+
+	other := key
+	other.ServiceKey = "other"
+
+	_, _, err := c.Get(context.Background(), key, fetchValue("v1", &calls))
+	require.NoError(t, err)
+	fields, _, err := c.Get(context.Background(), other, fetchValue("v2", &calls))
+	require.NoError(t, err)
+	require.Equal(t, "v2", fields["value"])
+	require.Equal(t, 2, calls)
+}
+
+func TestGet_ServesStaleOnError(t *testing.T) {
+	c, clock := newTestCache(Options{TTL: time.Minute, MaxStale: 10 * time.Minute})
+	calls := 0
+
+	_, _, err := c.Get(context.Background(), key, fetchValue("v1", &calls))
+	require.NoError(t, err)
+
+	clock.Advance(5 * time.Minute)
+	fields, stale, err := c.Get(context.Background(), key, fetchError(&calls))
+	require.ErrorIs(t, err, errUpstream)
+	require.True(t, stale)
+	require.Equal(t, "v1", fields["value"])
+
+	clock.Advance(5 * time.Minute)
+	fields, stale, err = c.Get(context.Background(), key, fetchError(&calls))
+	require.ErrorIs(t, err, errUpstream)
+	require.False(t, stale)
+	require.Nil(t, fields)
+	require.Equal(t, 0, c.Len())
+}
+
+func TestGet_NoStaleWithoutMaxStale(t *testing.T) {
+	c, clock := newTestCache(Options{TTL: time.Minute})
+	calls := 0
+
+	_, _, err := c.Get(context.Background(), key, fetchValue("v1", &calls))
+	require.NoError(t, err)
+
+	clock.Advance(2 * time.Minute)
+	fields, stale, err := c.Get(context.Background(), key, fetchError(&calls))
+	require.ErrorIs(t, err, errUpstream)
+	require.False(t, stale)
+	require.Nil(t, fields)
+}
+
+func TestGet_EvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(Options{TTL: time.Minute, MaxSize: 2})
+	calls := 0
+
+	keys := []Key{key, key, key}
+	keys[1].Name = "second"
+	keys[2].Name = "third"
+
+	for _, k := range keys[:2] {
+		_, _, err := c.Get(context.Background(), k, fetchValue(k.Name, &calls))
+		require.NoError(t, err)
+	}
+
+	// Touch the first key so that the second one becomes the least recently used
+	_, _, err := c.Get(context.Background(), keys[0], fetchValue("unused", &calls))
+	require.NoError(t, err)
+
+	_, _, err = c.Get(context.Background(), keys[2], fetchValue(keys[2].Name, &calls))
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, 3, calls)
+
+	_, _, err = c.Get(context.Background(), keys[0], fetchValue("unused", &calls))
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	_, _, err = c.Get(context.Background(), keys[1], fetchValue(keys[1].Name, &calls))
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Credential Store while the circuit breaker is open.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrUnavailable)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// BreakerOptions configures the circuit breaker around the Credential Store endpoint.
+type BreakerOptions struct {
+	// FailureRate in the range (0, 1] at which the breaker opens, 0 disables the breaker.
+	FailureRate float64
+	// WindowSize is the number of most recent requests the failure rate is computed from.
+	WindowSize int
+	// MinRequests is the number of requests in the window required before the breaker can open.
+	MinRequests int
+	// OpenDuration is the time the breaker stays open before it lets probe requests through.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of successful probe requests which close the breaker again.
+	HalfOpenProbes int
+	// OnStateChange is called on every state transition, e.g. to log it. It must not call the breaker.
+	OnStateChange func(from, to BreakerState)
+}
+
+// BreakerStatus is a snapshot of the breaker state.
+type BreakerStatus struct {
+	State       string    `json:"state"`
+	Requests    int       `json:"requests"`
+	Failures    int       `json:"failures"`
+	OpenedAt    time.Time `json:"openedAt"`
+	FailureRate float64   `json:"failureRate"`
+}
+
+// Breaker fails requests fast while Credential Store is unavailable. It opens when the
+// failure rate of the recent requests exceeds the configured rate, and closes again
+// after a number of successful probe requests. A nil Breaker lets every request through.
+type Breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	window   []bool
+	next     int
+	requests int
+	failures int
+	openedAt time.Time
+	probes   int
+	passed   int
+	// generation counts the state transitions, so that the outcome of a request
+	// is only recorded in the state which admitted it.
+	generation uint64
+}
+
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.FailureRate <= 0 {
+		return nil
+	}
+
+	if opts.WindowSize <= 0 {
+		opts.WindowSize = 1
+	}
+
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+
+	return &Breaker{
+		opts:   opts,
+		now:    time.Now,
+		window: make([]bool, opts.WindowSize),
+	}
+}
+
+// breakerResult is the outcome of a request as seen by the breaker.
+type breakerResult int
+
+const (
+	resultSuccess breakerResult = iota
+	resultFailure
+	// resultIgnored is used for requests which say nothing about the health of
+	// Credential Store, e.g. requests cancelled by the caller.
+	resultIgnored
+)
+
+// allow reports whether a request may be sent. Requests which were allowed must be
+// completed with done and the returned generation.
+func (b *Breaker) allow() (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.now().Sub(b.openedAt) < b.opts.OpenDuration {
+			return 0, ErrCircuitOpen
+		}
+
+		b.transition(BreakerHalfOpen)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.opts.HalfOpenProbes-b.passed {
+			return 0, ErrCircuitOpen
+		}
+
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+// done records the outcome of an allowed request. Requests which were admitted before
+// the last state transition are not recorded, e.g. a slow request sent while the
+// breaker was closed does not count as a probe once it is half-open.
+func (b *Breaker) done(generation uint64, result breakerResult) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	failed := result == resultFailure
+
+	if b.state == BreakerHalfOpen {
+		b.probes--
+
+		switch result {
+		case resultFailure:
+			b.open()
+		case resultSuccess:
+			b.passed++
+			if b.passed >= b.opts.HalfOpenProbes {
+				b.transition(BreakerClosed)
+			}
+		}
+
+		return
+	}
+
+	if result == resultIgnored {
+		return
+	}
+
+	if b.requests == len(b.window) {
+		if b.window[b.next] {
+			b.failures--
+		}
+	} else {
+		b.requests++
+	}
+
+	b.window[b.next] = failed
+	b.next = (b.next + 1) % len(b.window)
+	if failed {
+		b.failures++
+	}
+
+	if b.requests >= b.opts.MinRequests && b.failureRate() >= b.opts.FailureRate {
+		b.open()
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed.String()}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BreakerStatus{
+		State:       b.state.String(),
+		Requests:    b.requests,
+		Failures:    b.failures,
+		OpenedAt:    b.openedAt,
+		FailureRate: b.failureRate(),
+	}
+}
+
+func (b *Breaker) failureRate() float64 {
+	if b.requests == 0 {
+		return 0
+	}
+
+	return float64(b.failures) / float64(b.requests)
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	b.transition(BreakerOpen)
+}
+
+func (b *Breaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probes = 0
+	b.passed = 0
+
+	if to == BreakerClosed {
+		b.requests = 0
+		b.failures = 0
+		b.next = 0
+	}
+
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
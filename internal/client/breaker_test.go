@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type breakerClock struct {
+	now time.Time
+}
+
+func newTestBreaker(opts BreakerOptions) (*Breaker, *breakerClock, *[]string) {
+	clock := &breakerClock{now: time.Unix(0, 0)}
+	transitions := &[]string{}
+
+	opts.OnStateChange = func(from, to BreakerState) {
+		*transitions = append(*transitions, from.String()+"->"+to.String())
+	}
+
+	b := NewBreaker(opts)
+	b.now = func() time.Time { return clock.now }
+	return b, clock, transitions
+}
+
+func record(t *testing.T, b *Breaker, results ...breakerResult) {
+	t.Helper()
+
+	for _, result := range results {
+		generation, err := b.allow()
+		require.NoError(t, err)
+		b.done(generation, result)
+	}
+}
+
+func TestBreaker_OpensAtFailureRate(t *testing.T) {
+	b, _, transitions := newTestBreaker(BreakerOptions{FailureRate: 0.5, WindowSize: 4, MinRequests: 4, OpenDuration: time.Minute})
+
+	record(t, b, resultFailure, resultFailure, resultSuccess)
+	require.Equal(t, BreakerClosed, b.State(), "min requests not reached")
+
+	record(t, b, resultSuccess)
+	require.Equal(t, BreakerOpen, b.State())
+	_, err := b.allow()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.Equal(t, []string{"closed->open"}, *transitions)
+}
+
+func TestBreaker_SlidingWindow(t *testing.T) {
+	b, _, _ := newTestBreaker(BreakerOptions{FailureRate: 0.75, WindowSize: 4, MinRequests: 4, OpenDuration: time.Minute})
+
+	record(t, b, resultFailure, resultFailure, resultSuccess, resultSuccess)
+	require.Equal(t, BreakerClosed, b.State())
+
+	// The oldest failures drop out of the window, so one new failure is not enough
+	record(t, b, resultSuccess, resultSuccess, resultFailure)
+	require.Equal(t, BreakerClosed, b.State())
+	require.Equal(t, 1, b.Status().Failures)
+
+	record(t, b, resultFailure, resultFailure)
+	require.Equal(t, BreakerOpen, b.State())
+}
+
+func TestBreaker_IgnoredResults(t *testing.T) {
+	b, _, _ := newTestBreaker(BreakerOptions{FailureRate: 0.5, WindowSize: 2, MinRequests: 2, OpenDuration: time.Minute})
+
+	record(t, b, resultIgnored, resultIgnored, resultFailure, resultIgnored)
+	require.Equal(t, BreakerClosed, b.State())
+	require.Equal(t, 1, b.Status().Requests)
+}
+
+func TestBreaker_HalfOpenProbes(t *testing.T) {
+	b, clock, transitions := newTestBreaker(BreakerOptions{FailureRate: 1, WindowSize: 1, MinRequests: 1, OpenDuration: time.Minute, HalfOpenProbes: 2})
+
+	record(t, b, resultFailure)
+	require.Equal(t, BreakerOpen, b.State())
+
+	clock.now = clock.now.Add(time.Minute)
+	first, err := b.allow()
+	require.NoError(t, err)
+	second, err := b.allow()
+	require.NoError(t, err)
+	_, err = b.allow()
+	require.ErrorIs(t, err, ErrCircuitOpen, "only two concurrent probes allowed")
+	require.Equal(t, BreakerHalfOpen, b.State())
+
+	b.done(first, resultSuccess)
+	b.done(second, resultFailure)
+	require.Equal(t, BreakerOpen, b.State(), "failed probe reopens the breaker")
+
+	clock.now = clock.now.Add(time.Minute)
+	record(t, b, resultSuccess, resultSuccess)
+	require.Equal(t, BreakerClosed, b.State())
+	require.Equal(t, BreakerStatus{State: "closed", OpenedAt: clock.now.Add(-time.Minute)}, b.Status())
+
+	require.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, *transitions)
+}
+
+func TestBreaker_LateResultsAreNotProbes(t *testing.T) {
+	b, clock, transitions := newTestBreaker(BreakerOptions{FailureRate: 1, WindowSize: 2, MinRequests: 2, OpenDuration: time.Minute, HalfOpenProbes: 2})
+
+	// a slow request is admitted while closed and finishes after the breaker turned half-open
+	slow, err := b.allow()
+	require.NoError(t, err)
+	record(t, b, resultFailure, resultFailure)
+	require.Equal(t, BreakerOpen, b.State())
+
+	clock.now = clock.now.Add(time.Minute)
+	probe, err := b.allow()
+	require.NoError(t, err)
+
+	b.done(slow, resultSuccess)
+	b.done(probe, resultSuccess)
+	require.Equal(t, BreakerHalfOpen, b.State(), "the slow request does not count as a probe")
+
+	_, err = b.allow()
+	require.NoError(t, err, "the slow request does not take a probe slot")
+	_, err = b.allow()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	require.Equal(t, []string{"closed->open", "open->half-open"}, *transitions)
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	var b *Breaker = NewBreaker(BreakerOptions{})
+	require.Nil(t, b)
+
+	record(t, b, resultFailure, resultFailure)
+	require.Equal(t, BreakerClosed, b.State())
+	require.Equal(t, "closed", b.Status().State)
+}
+
+func TestGetPassword_BreakerFailsFast(t *testing.T) {
+	var calls int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := newMockClient(srv)
+	c.Breaker = NewBreaker(BreakerOptions{FailureRate: 1, WindowSize: 2, MinRequests: 2, OpenDuration: time.Minute})
+	c.Retry = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.EqualValues(t, 2, calls, "breaker opened during retries")
+
+	_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.EqualValues(t, 2, calls)
+}
+
+func TestGetPassword_BreakerIgnoresClientErrors(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	c := newMockClient(srv)
+	c.Breaker = NewBreaker(BreakerOptions{FailureRate: 1, WindowSize: 2, MinRequests: 2, OpenDuration: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+
+	require.Equal(t, BreakerClosed, c.Breaker.State())
+}
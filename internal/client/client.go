@@ -1,11 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"time"
 
@@ -31,38 +33,193 @@ type KeyCredential struct {
 	Value      string `json:"value"`
 }
 
+type CertificateCredential struct {
+	ID         string `json:"id"`
+	ModifiedAt string `json:"modifiedAt"`
+	Name       string `json:"name"`
+	Metadata   string `json:"metadata"`
+	Value      string `json:"value"`
+	Key        string `json:"key"`
+	Chain      string `json:"chain"`
+}
+
+// Fields returns the mountable fields of the password keyed by their JSON name.
+func (p *PasswordCredential) Fields() map[string]string {
+	return map[string]string{
+		"id":         p.ID,
+		"modifiedAt": p.ModifiedAt,
+		"name":       p.Name,
+		"username":   p.Username,
+		"metadata":   p.Metadata,
+		"value":      p.Value,
+	}
+}
+
+// Fields returns the mountable fields of the key keyed by their JSON name.
+func (k *KeyCredential) Fields() map[string]string {
+	return map[string]string{
+		"id":         k.ID,
+		"modifiedAt": k.ModifiedAt,
+		"name":       k.Name,
+		"format":     k.Format,
+		"username":   k.Username,
+		"metadata":   k.Metadata,
+		"value":      k.Value,
+	}
+}
+
+// Fields returns the mountable fields of the certificate keyed by their JSON name.
+func (c *CertificateCredential) Fields() map[string]string {
+	return map[string]string{
+		"id":         c.ID,
+		"modifiedAt": c.ModifiedAt,
+		"name":       c.Name,
+		"metadata":   c.Metadata,
+		"value":      c.Value,
+		"key":        c.Key,
+		"chain":      c.Chain,
+	}
+}
+
+// idleConnTimeout closes pooled connections which are not reused, e.g. the ones which
+// requests in flight return to a client after it was replaced.
+const idleConnTimeout = 90 * time.Second
+
 type Client struct {
-	BaseURL   string
-	HTTP      *http.Client
-	Decryptor JWEDecryptor
+	BaseURL     string
+	Fingerprint string
+	HTTP        *http.Client
+	Decryptor   JWEDecryptor
+	Retry       RetryPolicy
+	Breaker     *Breaker
+	// Verifier checks the signatures of decrypted payloads, nil disables the verification.
+	Verifier *JWSVerifier
+	// Encryptor encrypts the bodies of requests which create credentials, nil if the
+	// service key has no server public key.
+	Encryptor *JWEEncryptor
+	// Plaintext is set for Credential Store instances with payload encryption disabled.
+	// Otherwise, plain JSON responses are rejected.
+	Plaintext bool
+	// HideErrorMessages leaves the bodies of error responses out of the returned errors.
+	HideErrorMessages bool
+	// tokens authorizes requests of OAuth bindings, nil for mTLS bindings.
+	tokens *tokenSource
 }
 
-func NewClient(serviceKey config.ServiceKey, decryptor JWEDecryptor, timeout time.Duration) (*Client, error) {
-	cert, err := tls.X509KeyPair([]byte(serviceKey.Certificate), []byte(serviceKey.Key))
+// Options configures the connection to Credential Store.
+type Options struct {
+	// Timeout bounds a single http request attempt.
+	Timeout time.Duration
+	Retry   RetryPolicy
+	TLS     TLSOptions
+	Proxy   ProxyOptions
+	// Breaker is shared by all clients which talk to the same Credential Store instance,
+	// a nil Breaker disables the circuit breaker.
+	Breaker *Breaker
+	// Verifier checks the signatures of decrypted payloads, a nil Verifier disables the verification.
+	Verifier *JWSVerifier
+	// AllowedHosts restricts the Credential Store and token endpoints of the service key,
+	// including redirects, to https URLs of matching hosts, see ParseHosts. Empty allows any.
+	AllowedHosts []string
+	// HideErrorMessages leaves the bodies of error responses out of the returned errors,
+	// for service keys whose endpoints the operator does not control.
+	HideErrorMessages bool
+}
+
+func NewClient(serviceKey config.ServiceKey, decryptor JWEDecryptor, opts Options) (*Client, error) {
+	var checkRedirectFunc func(req *http.Request, via []*http.Request) error
+	if len(opts.AllowedHosts) != 0 {
+		if err := checkEndpoint(serviceKey.URL, opts.AllowedHosts); err != nil {
+			return nil, fmt.Errorf("service key url: %v", err)
+		}
+
+		if serviceKey.OAuth != nil {
+			if err := checkEndpoint(serviceKey.OAuth.TokenURL, opts.AllowedHosts); err != nil {
+				return nil, fmt.Errorf("service key oauth.token_url: %v", err)
+			}
+		}
+
+		checkRedirectFunc = checkRedirect(opts.AllowedHosts)
+	}
+
+	tlsConfig, err := newTLSConfig(serviceKey, opts.TLS)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse x509 key pair: %v", err)
+		return nil, err
 	}
 
-	return &Client{
-		BaseURL: serviceKey.URL,
+	proxy, err := newProxyFunc(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor, err := NewJWEEncryptor(serviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		BaseURL:     serviceKey.URL,
+		Fingerprint: serviceKey.Fingerprint(),
 		HTTP: &http.Client{
-			Timeout: timeout,
+			Timeout:       opts.Timeout,
+			CheckRedirect: checkRedirectFunc,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					Certificates: []tls.Certificate{cert},
-				},
+				Proxy:           proxy,
+				TLSClientConfig: tlsConfig,
+				IdleConnTimeout: idleConnTimeout,
 			},
 		},
-		Decryptor: decryptor,
-	}, nil
+		Decryptor:         decryptor,
+		Verifier:          opts.Verifier,
+		Encryptor:         encryptor,
+		Plaintext:         !serviceKey.EncryptionEnabled(),
+		Retry:             opts.Retry,
+		Breaker:           opts.Breaker,
+		HideErrorMessages: opts.HideErrorMessages,
+	}
+
+	if serviceKey.OAuth != nil {
+		// the token endpoint is a different server, so the pinned keys of Credential Store do not apply
+		tokenTLS := opts.TLS
+		tokenTLS.PinnedSPKI = nil
+		tokenTLSConfig, err := newTLSConfig(serviceKey, tokenTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		c.tokens = newTokenSource(*serviceKey.OAuth, &http.Client{
+			Timeout:       opts.Timeout,
+			CheckRedirect: checkRedirectFunc,
+			Transport: &http.Transport{
+				Proxy:           proxy,
+				TLSClientConfig: tokenTLSConfig,
+				IdleConnTimeout: idleConnTimeout,
+			},
+		})
+		c.tokens.hideErrorMessages = opts.HideErrorMessages
+	}
+
+	return c, nil
+}
+
+// CloseIdleConnections closes the idle connections to Credential Store and the token
+// endpoint, e.g. after the client was replaced by one for a rotated service key.
+func (c *Client) CloseIdleConnections() {
+	if c.HTTP != nil {
+		c.HTTP.CloseIdleConnections()
+	}
+
+	if c.tokens != nil {
+		c.tokens.http.CloseIdleConnections()
+	}
 }
 
 func (c *Client) GetPassword(ctx context.Context, namespace, name string) (*PasswordCredential, error) {
 	url := fmt.Sprintf("%s/password?name=%s", c.BaseURL, name)
 	password := &PasswordCredential{}
-	err := c.getRequest(ctx, url, namespace, password)
+	err := c.getRequest(ctx, url, request{namespace: namespace, credType: "password", name: name}, password)
 	if err != nil {
-		return nil, fmt.Errorf("could not get password %s/%s from credstore: %v", namespace, name, err)
+		return nil, fmt.Errorf("could not get password %s/%s from credstore: %w", namespace, name, err)
 	}
 
 	return password, nil
@@ -71,40 +228,146 @@ func (c *Client) GetPassword(ctx context.Context, namespace, name string) (*Pass
 func (c *Client) GetKey(ctx context.Context, namespace, name string) (*KeyCredential, error) {
 	url := fmt.Sprintf("%s/key?name=%s", c.BaseURL, name)
 	key := &KeyCredential{}
-	err := c.getRequest(ctx, url, namespace, key)
+	err := c.getRequest(ctx, url, request{namespace: namespace, credType: "key", name: name}, key)
 	if err != nil {
-		return nil, fmt.Errorf("could not get key %s/%s from credstore: %v", namespace, name, err)
+		return nil, fmt.Errorf("could not get key %s/%s from credstore: %w", namespace, name, err)
 	}
 
 	return key, nil
 }
 
-func (c *Client) getRequest(ctx context.Context, url, namespace string, cred interface{}) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func (c *Client) GetCertificate(ctx context.Context, namespace, name string) (*CertificateCredential, error) {
+	url := fmt.Sprintf("%s/certificate?name=%s", c.BaseURL, name)
+	cert := &CertificateCredential{}
+	err := c.getRequest(ctx, url, request{namespace: namespace, credType: "certificate", name: name}, cert)
 	if err != nil {
-		return fmt.Errorf("could not build http request: %v", err)
+		return nil, fmt.Errorf("could not get certificate %s/%s from credstore: %w", namespace, name, err)
 	}
 
-	req.Header.Set("sapcp-credstore-namespace", namespace)
+	return cert, nil
+}
 
-	resp, err := c.HTTP.Do(req)
+// ListCredentials returns the names of all credentials of the given type in the namespace.
+func (c *Client) ListCredentials(ctx context.Context, credType, namespace string) ([]string, error) {
+	url := fmt.Sprintf("%s/%ss", c.BaseURL, credType)
+	var creds []struct {
+		Name string `json:"name"`
+	}
+
+	err := c.getRequest(ctx, url, request{namespace: namespace, credType: credType}, &creds)
 	if err != nil {
-		return fmt.Errorf("http request failed: %v", err)
+		return nil, fmt.Errorf("could not list %ss in %s from credstore: %w", credType, namespace, err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status: got %v", resp.Status)
+	names := make([]string, len(creds))
+	for i, cred := range creds {
+		names[i] = cred.Name
 	}
 
-	jwe, err := io.ReadAll(resp.Body)
+	return names, nil
+}
+
+// CreatePassword creates a password in Credential Store. It fails with ErrConflict if
+// the password already exists.
+func (c *Client) CreatePassword(ctx context.Context, namespace, name, value string) (*PasswordCredential, error) {
+	url := fmt.Sprintf("%s/password", c.BaseURL)
+	password := &PasswordCredential{}
+	err := c.postRequest(ctx, url, request{namespace: namespace, credType: "password", name: name}, createRequest{Name: name, Value: value}, password)
 	if err != nil {
-		return fmt.Errorf("could not read response body: %v", err)
+		return nil, fmt.Errorf("could not create password %s/%s in credstore: %w", namespace, name, err)
 	}
 
-	decrypted, err := c.Decryptor.Decrypt(jwe)
+	return password, nil
+}
+
+// CreateKey creates a key with a base64 encoded value in Credential Store. It fails with
+// ErrConflict if the key already exists.
+func (c *Client) CreateKey(ctx context.Context, namespace, name, format, value string) (*KeyCredential, error) {
+	url := fmt.Sprintf("%s/key", c.BaseURL)
+	key := &KeyCredential{}
+	err := c.postRequest(ctx, url, request{namespace: namespace, credType: "key", name: name}, createRequest{Name: name, Format: format, Value: value}, key)
 	if err != nil {
-		return fmt.Errorf("could not decrypt response body: %v", err)
+		return nil, fmt.Errorf("could not create key %s/%s in credstore: %w", namespace, name, err)
+	}
+
+	return key, nil
+}
+
+type createRequest struct {
+	Name   string `json:"name"`
+	Format string `json:"format,omitempty"`
+	Value  string `json:"value"`
+}
+
+// request is a single Credential Store request, body is nil for GET requests. name is
+// the credential the response has to describe, empty for list requests.
+type request struct {
+	method      string
+	url         string
+	namespace   string
+	credType    string
+	name        string
+	body        []byte
+	contentType string
+}
+
+func (c *Client) getRequest(ctx context.Context, url string, req request, cred interface{}) error {
+	req.method, req.url = http.MethodGet, url
+	return c.do(ctx, req, cred)
+}
+
+// postRequest sends the body encrypted for the server public key, unless the Credential
+// Store instance has payload encryption disabled.
+func (c *Client) postRequest(ctx context.Context, url string, req request, body, cred interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("could not encode request body: %v", err)
+	}
+
+	contentType := "application/json"
+	if !c.Plaintext {
+		if c.Encryptor == nil {
+			return fmt.Errorf("service key encryption.server_public_key is required to create credentials")
+		}
+
+		payload, err = c.Encryptor.Encrypt(payload)
+		if err != nil {
+			return err
+		}
+
+		contentType = "application/jose"
+	}
+
+	req.method, req.url, req.body, req.contentType = http.MethodPost, url, payload, contentType
+	return c.do(ctx, req, cred)
+}
+
+// do sends the request with retries and decodes the decrypted and verified response
+// body into cred. Retried POST requests which were applied before fail with ErrConflict.
+func (c *Client) do(ctx context.Context, req request, cred interface{}) error {
+	var header http.Header
+	body, err := c.doWithRetry(ctx, func(ctx context.Context) ([]byte, error) {
+		body, h, err := c.doRequest(ctx, req)
+		header = h
+		return body, err
+	})
+	if err != nil {
+		return err
+	}
+	decrypted, err := c.decrypt(body, header)
+	if err != nil {
+		return &decryptionError{err: err}
+	}
+
+	if c.Verifier != nil {
+		decrypted, err = c.Verifier.Verify(decrypted, header)
+		if err == nil {
+			err = checkEntry(decrypted, req)
+		}
+
+		if err != nil {
+			return &signatureError{err: err}
+		}
 	}
 
 	err = json.Unmarshal(decrypted, cred)
@@ -114,3 +377,79 @@ func (c *Client) getRequest(ctx context.Context, url, namespace string, cred int
 
 	return nil
 }
+
+// decrypt returns the payload of a response. Encrypted and plaintext responses are told
+// apart by their content type, and a response which does not match the encryption mode
+// of the service key is rejected, so that encryption cannot be stripped on the way.
+func (c *Client) decrypt(body []byte, header http.Header) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case c.Plaintext && mediaType == "application/jose":
+		return nil, fmt.Errorf("response is encrypted, but the service key has no encryption key")
+	case c.Plaintext:
+		return body, nil
+	case mediaType == "application/json":
+		return nil, fmt.Errorf("response is not encrypted, but the service key expects payload encryption")
+	default:
+		return c.Decryptor.Decrypt(body)
+	}
+}
+
+func (c *Client) doRequest(ctx context.Context, req request) ([]byte, http.Header, error) {
+	body, header, token, err := c.send(ctx, req)
+
+	// a token can be revoked before it expires, so retry once with a fresh one
+	var respErr *ResponseError
+	if c.tokens != nil && errors.As(err, &respErr) && respErr.StatusCode == http.StatusUnauthorized {
+		c.tokens.invalidate(token)
+		body, header, _, err = c.send(ctx, req)
+	}
+
+	return body, header, err
+}
+
+// send performs a single request and returns the response headers and the bearer token
+// it was authorized with, if any.
+func (c *Client) send(ctx context.Context, r request) ([]byte, http.Header, string, error) {
+	var reqBody io.Reader
+	if r.body != nil {
+		reqBody = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, reqBody)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("could not build http request: %v", err)
+	}
+
+	req.Header.Set("sapcp-credstore-namespace", r.namespace)
+	if len(r.contentType) != 0 {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+
+	var token string
+	if c.tokens != nil {
+		token, err = c.tokens.get(ctx)
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, token, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, token, &transportError{err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, nil, token, newResponseError(resp, body, c.HideErrorMessages)
+	}
+
+	return body, resp.Header, token, nil
+}
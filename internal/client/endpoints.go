@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ParseHosts validates host name patterns for Options.AllowedHosts. Patterns use
+// path.Match syntax, e.g. *.example.com, and are matched against host names without port.
+func ParseHosts(patterns []string) ([]string, error) {
+	var hosts []string
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %s: %v", pattern, err)
+		}
+
+		hosts = append(hosts, pattern)
+	}
+
+	return hosts, nil
+}
+
+// checkEndpoint fails unless rawURL is an https URL whose host matches one of hosts.
+func checkEndpoint(rawURL string, hosts []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+
+	if u.Scheme != "https" {
+		return fmt.Errorf("url %s does not use https", u.Redacted())
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s of url %s is not allowed", host, u.Redacted())
+}
+
+// checkRedirect keeps redirects on allowed hosts, so that an allowed server cannot
+// forward requests to an endpoint which the service key could not name itself.
+func checkRedirect(hosts []string) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		return checkEndpoint(req.URL.String(), hosts)
+	}
+}
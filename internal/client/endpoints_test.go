@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts([]string{" *.Example.com", "credstore.local"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.example.com", "credstore.local"}, hosts)
+
+	_, err = ParseHosts([]string{"[a-"})
+	require.ErrorContains(t, err, "invalid host pattern [a-")
+}
+
+func TestNewClient_AllowedHosts(t *testing.T) {
+	oauthKey := func(url, tokenURL string) config.ServiceKey {
+		return config.ServiceKey{URL: url, OAuth: &config.OAuthCredentials{TokenURL: tokenURL, ClientID: "my-client", ClientSecret: "my-secret"}}
+	}
+
+	data := []struct {
+		name       string
+		serviceKey config.ServiceKey
+		errorMsg   string
+	}{
+		{name: "allowed host", serviceKey: newServiceKey(t, "https://credstore.example.com/api/v1/credentials")},
+		{name: "allowed token host", serviceKey: oauthKey("https://credstore.example.com", "https://auth.example.com/oauth/token")},
+		{name: "http", serviceKey: newServiceKey(t, "http://credstore.example.com"), errorMsg: "service key url: url http://credstore.example.com does not use https"},
+		{name: "other host", serviceKey: newServiceKey(t, "https://169.254.169.254/latest"), errorMsg: "service key url: host 169.254.169.254 of url https://169.254.169.254/latest is not allowed"},
+		{name: "suffix of allowed host", serviceKey: newServiceKey(t, "https://example.com.evil"), errorMsg: "host example.com.evil of url https://example.com.evil is not allowed"},
+		{name: "other token host", serviceKey: oauthKey("https://credstore.example.com", "https://localhost:8080/token"), errorMsg: "service key oauth.token_url: host localhost"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := NewClient(d.serviceKey, decryptor, Options{AllowedHosts: []string{"*.example.com"}})
+			if len(d.errorMsg) != 0 {
+				require.ErrorContains(t, err, d.errorMsg)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewClient_AllowedHostsRedirect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://metadata.internal/latest", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(newServiceKey(t, srv.URL), decryptor, Options{TLS: TLSOptions{CAFile: writeCAFile(t, srv)}, AllowedHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+
+	_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorContains(t, err, "host metadata.internal of url https://metadata.internal/latest is not allowed")
+}
+
+func TestNewClient_HideErrorMessages(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal response", http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, hide := range []bool{false, true} {
+		c, err := NewClient(newServiceKey(t, srv.URL), decryptor, Options{TLS: TLSOptions{CAFile: writeCAFile(t, srv)}, HideErrorMessages: hide})
+		require.NoError(t, err)
+
+		_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+		require.ErrorContains(t, err, "unexpected status: got 400 Bad Request")
+		if hide {
+			require.NotContains(t, err.Error(), "internal response")
+		} else {
+			require.ErrorContains(t, err, "internal response")
+		}
+	}
+}
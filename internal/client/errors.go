@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sentinel errors which classify failed Credential Store requests, check them with errors.Is.
+var (
+	ErrNotFound         = errors.New("credential not found")
+	ErrConflict         = errors.New("credential already exists")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrUnavailable      = errors.New("credstore unavailable")
+	ErrDecryptionFailed = errors.New("decryption failed")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// maxErrorMessageLength bounds the part of an error response body kept in ResponseError.
+const maxErrorMessageLength = 512
+
+// ResponseError is returned when Credential Store responds with an unexpected status.
+type ResponseError struct {
+	StatusCode int
+	Status     string
+	// Message is the error message reported in the response body, if any.
+	Message    string
+	RetryAfter time.Duration
+}
+
+// newResponseError keeps the error message of the body unless hideMessage is set.
+func newResponseError(resp *http.Response, body []byte, hideMessage bool) *ResponseError {
+	respErr := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	if !hideMessage {
+		respErr.Message = parseErrorMessage(body)
+	}
+
+	return respErr
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("unexpected status: got %v", e.Status)
+	}
+
+	return fmt.Sprintf("unexpected status: got %v: %s", e.Status, e.Message)
+}
+
+// Unwrap maps the status code to one of the sentinel errors.
+func (e *ResponseError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrUnavailable
+	}
+
+	return nil
+}
+
+// transportError is returned when Credential Store could not be reached or the
+// response could not be read.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("http request failed: %v", e.err)
+}
+
+func (e *transportError) Unwrap() []error {
+	return []error{ErrUnavailable, e.err}
+}
+
+// decryptionError is returned when a response body cannot be decrypted.
+type decryptionError struct {
+	err error
+}
+
+func (e *decryptionError) Error() string {
+	return fmt.Sprintf("could not decrypt response body: %v", e.err)
+}
+
+func (e *decryptionError) Unwrap() []error {
+	return []error{ErrDecryptionFailed, e.err}
+}
+
+// signatureError is returned when a decrypted response body is not signed by a trusted key.
+type signatureError struct {
+	err error
+}
+
+func (e *signatureError) Error() string {
+	return fmt.Sprintf("could not verify response body: %v", e.err)
+}
+
+func (e *signatureError) Unwrap() []error {
+	return []error{ErrInvalidSignature, e.err}
+}
+
+// parseErrorMessage extracts the message of a JSON error response and falls back to the plain body.
+func parseErrorMessage(body []byte) string {
+	var jsonBody struct {
+		Message string          `json:"message"`
+		Error   json.RawMessage `json:"error"`
+	}
+
+	message := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &jsonBody); err == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+
+		var str string
+		switch {
+		case len(jsonBody.Message) != 0:
+			message = jsonBody.Message
+		case json.Unmarshal(jsonBody.Error, &str) == nil && len(str) != 0:
+			message = str
+		case json.Unmarshal(jsonBody.Error, &nested) == nil && len(nested.Message) != 0:
+			message = nested.Message
+		}
+	}
+
+	if len(message) > maxErrorMessageLength {
+		message = message[:maxErrorMessageLength] + "..."
+	}
+
+	return message
+}
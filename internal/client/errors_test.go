@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPassword_TypedErrors(t *testing.T) {
+	data := []struct {
+		name     string
+		status   int
+		body     string
+		kind     error
+		errorMsg string
+	}{
+		{
+			name:     "not found with json message",
+			status:   http.StatusNotFound,
+			body:     `{"message": "Credential myPassword not found"}`,
+			kind:     ErrNotFound,
+			errorMsg: "unexpected status: got 404 Not Found: Credential myPassword not found",
+		},
+		{
+			name:     "unauthorized with nested json error",
+			status:   http.StatusUnauthorized,
+			body:     `{"error": {"message": "certificate expired"}}`,
+			kind:     ErrUnauthorized,
+			errorMsg: "unexpected status: got 401 Unauthorized: certificate expired",
+		},
+		{
+			name:     "forbidden with plain text",
+			status:   http.StatusForbidden,
+			body:     "access denied\n",
+			kind:     ErrForbidden,
+			errorMsg: "unexpected status: got 403 Forbidden: access denied",
+		},
+		{
+			name:     "rate limited with json error string",
+			status:   http.StatusTooManyRequests,
+			body:     `{"error": "quota exceeded"}`,
+			kind:     ErrRateLimited,
+			errorMsg: "unexpected status: got 429 Too Many Requests: quota exceeded",
+		},
+		{
+			name:     "unavailable without body",
+			status:   http.StatusServiceUnavailable,
+			kind:     ErrUnavailable,
+			errorMsg: "unexpected status: got 503 Service Unavailable",
+		},
+		{
+			name:     "undecryptable body",
+			status:   http.StatusOK,
+			body:     "not a jwe",
+			kind:     ErrDecryptionFailed,
+			errorMsg: "could not decrypt response body",
+		},
+	}
+
+	for _, d := range data {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(d.status)
+			w.Write([]byte(d.body))
+		}))
+
+		actual, err := newMockClient(srv).GetPassword(context.Background(), "dev", "myPassword")
+		require.ErrorIs(t, err, d.kind, d.name)
+		require.ErrorContains(t, err, d.errorMsg, d.name)
+		require.Nil(t, actual, d.name)
+
+		srv.Close()
+	}
+}
+
+func TestGetPassword_ConnectionRefused(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	c := newMockClient(srv)
+	srv.Close()
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.ErrorContains(t, err, "http request failed")
+}
+
+func TestParseErrorMessage_Truncates(t *testing.T) {
+	body := make([]byte, 2*maxErrorMessageLength)
+	for i := range body {
+		body[i] = 'a'
+	}
+
+	message := parseErrorMessage(body)
+	require.Len(t, message, maxErrorMessageLength+3)
+}
@@ -1,38 +1,378 @@
 package client
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"strings"
 
 	"github.com/kloyan/credstore-csi-provider/internal/config"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 )
 
+// JWEOptions restricts the algorithms a JWEDecryptor accepts. Responses using any other
+// algorithm are rejected before they are decrypted, so that a server cannot downgrade them.
+type JWEOptions struct {
+	// KeyAlgorithms are the accepted key management algorithms, all but RSA-OAEP if empty.
+	KeyAlgorithms []jwa.KeyEncryptionAlgorithm
+	// ContentAlgorithms are the accepted content encryption algorithms, all if empty.
+	ContentAlgorithms []jwa.ContentEncryptionAlgorithm
+}
+
+var (
+	defaultKeyAlgorithms = []jwa.KeyEncryptionAlgorithm{
+		jwa.RSA_OAEP_256,
+		jwa.ECDH_ES, jwa.ECDH_ES_A128KW, jwa.ECDH_ES_A192KW, jwa.ECDH_ES_A256KW,
+	}
+	// RSA-OAEP uses SHA-1 and has to be allowed explicitly. RSA-OAEP-384 and RSA-OAEP-512
+	// are not supported, as jwx cannot decrypt them.
+	supportedKeyAlgorithms = append([]jwa.KeyEncryptionAlgorithm{jwa.RSA_OAEP}, defaultKeyAlgorithms...)
+
+	supportedContentAlgorithms = []jwa.ContentEncryptionAlgorithm{
+		jwa.A128GCM, jwa.A192GCM, jwa.A256GCM,
+		jwa.A128CBC_HS256, jwa.A192CBC_HS384, jwa.A256CBC_HS512,
+	}
+)
+
+// ParseKeyAlgorithms converts JWA names such as RSA-OAEP-256 to key management algorithms.
+// Algorithms which the decryptor does not support, e.g. RSA1_5, are rejected.
+func ParseKeyAlgorithms(names []string) ([]jwa.KeyEncryptionAlgorithm, error) {
+	var algs []jwa.KeyEncryptionAlgorithm
+	for _, name := range names {
+		alg := jwa.KeyEncryptionAlgorithm(strings.TrimSpace(name))
+		if !contains(supportedKeyAlgorithms, alg) {
+			return nil, fmt.Errorf("unsupported key management algorithm %s", name)
+		}
+
+		algs = append(algs, alg)
+	}
+
+	return algs, nil
+}
+
+// ParseContentAlgorithms converts JWA names such as A256GCM to content encryption algorithms.
+func ParseContentAlgorithms(names []string) ([]jwa.ContentEncryptionAlgorithm, error) {
+	var algs []jwa.ContentEncryptionAlgorithm
+	for _, name := range names {
+		alg := jwa.ContentEncryptionAlgorithm(strings.TrimSpace(name))
+		if !contains(supportedContentAlgorithms, alg) {
+			return nil, fmt.Errorf("unsupported content encryption algorithm %s", name)
+		}
+
+		algs = append(algs, alg)
+	}
+
+	return algs, nil
+}
+
+// JWEDecryptor decrypts Credential Store responses with one of several private keys,
+// so that responses encrypted for the old and the new key both succeed while the
+// encryption key pair is rotated.
 type JWEDecryptor struct {
+	keys              []decryptionKey
+	keyAlgorithms     []jwa.KeyEncryptionAlgorithm
+	contentAlgorithms []jwa.ContentEncryptionAlgorithm
+}
+
+type decryptionKey struct {
+	// id is the RFC 7638 thumbprint of the public key, matched against the JWE kid header.
+	id string
+	// privkey is either an *rsa.PrivateKey or an *ecdsa.PrivateKey.
 	privkey any
 }
 
-func NewJWEDecryptor(serviceKey config.ServiceKey) (JWEDecryptor, error) {
-	bytes, err := base64.StdEncoding.DecodeString(serviceKey.Encryption.ClientPrivateKey)
+// NewJWEDecryptor creates a decryptor for the client private key of the service key, its
+// additional private keys and the given extra keys, e.g. from a key directory. Keys are
+// RSA or EC keys in PKCS#8, PKCS#1 or SEC1 format, either PEM encoded or base64 encoded DER.
+func NewJWEDecryptor(serviceKey config.ServiceKey, opts JWEOptions, extraKeys ...string) (JWEDecryptor, error) {
+	encoded := append([]string{serviceKey.Encryption.ClientPrivateKey}, serviceKey.Encryption.AdditionalPrivateKeys...)
+	encoded = append(encoded, extraKeys...)
+
+	keys := make([]decryptionKey, 0, len(encoded))
+	for i, privkey := range encoded {
+		key, err := parseDecryptionKey(privkey)
+		if err != nil {
+			if i == 0 {
+				return JWEDecryptor{}, err
+			}
+
+			return JWEDecryptor{}, fmt.Errorf("additional key %d: %v", i, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	keyAlgorithms := opts.KeyAlgorithms
+	if len(keyAlgorithms) == 0 {
+		keyAlgorithms = defaultKeyAlgorithms
+	}
+
+	contentAlgorithms := opts.ContentAlgorithms
+	if len(contentAlgorithms) == 0 {
+		contentAlgorithms = supportedContentAlgorithms
+	}
+
+	return JWEDecryptor{keys: keys, keyAlgorithms: keyAlgorithms, contentAlgorithms: contentAlgorithms}, nil
+}
+
+func parseDecryptionKey(encoded string) (decryptionKey, error) {
+	der, err := decodeKey(encoded)
+	if err != nil {
+		return decryptionKey{}, fmt.Errorf("could not decode private key: %v", err)
+	}
+
+	privkey, err := parsePrivateKey(der)
+	if err != nil {
+		return decryptionKey{}, fmt.Errorf("could not parse private key: %v", err)
+	}
+
+	id, err := keyThumbprint(privkey)
+	if err != nil {
+		return decryptionKey{}, err
+	}
+
+	return decryptionKey{id: id, privkey: privkey}, nil
+}
+
+// decodeKey returns the DER bytes of a PEM block, of base64 encoded DER or of a base64
+// encoded PEM block.
+func decodeKey(encoded string) ([]byte, error) {
+	if block, _ := pem.Decode([]byte(strings.TrimSpace(encoded))); block != nil {
+		return block.Bytes, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(der); block != nil {
+		return block.Bytes, nil
+	}
+
+	return der, nil
+}
+
+func parsePrivateKey(der []byte) (any, error) {
+	var privkey any
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		privkey = key
+	} else if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		privkey = key
+	} else if key, err := x509.ParseECPrivateKey(der); err == nil {
+		privkey = key
+	} else {
+		return nil, fmt.Errorf("not a PKCS#8, PKCS#1 or SEC1 private key")
+	}
+
+	switch privkey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return privkey, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privkey)
+	}
+}
+
+// JWEEncryptor encrypts request bodies for the server public key of a service key.
+type JWEEncryptor struct {
+	pubkey any
+	alg    jwa.KeyEncryptionAlgorithm
+}
+
+// NewJWEEncryptor creates an encryptor for the server public key of the service key. It
+// returns nil if the service key has no server public key.
+func NewJWEEncryptor(serviceKey config.ServiceKey) (*JWEEncryptor, error) {
+	if len(serviceKey.Encryption.ServerPublicKey) == 0 {
+		return nil, nil
+	}
+
+	der, err := decodeKey(serviceKey.Encryption.ServerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode server public key: %v", err)
+	}
+
+	pubkey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		if pkcs1, pkcs1Err := x509.ParsePKCS1PublicKey(der); pkcs1Err == nil {
+			pubkey, err = pkcs1, nil
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse server public key: %v", err)
+	}
+
+	switch pubkey.(type) {
+	case *rsa.PublicKey:
+		return &JWEEncryptor{pubkey: pubkey, alg: jwa.RSA_OAEP_256}, nil
+	case *ecdsa.PublicKey:
+		return &JWEEncryptor{pubkey: pubkey, alg: jwa.ECDH_ES_A256KW}, nil
+	default:
+		return nil, fmt.Errorf("unsupported server public key type %T", pubkey)
+	}
+}
+
+// Encrypt returns the payload as compact JWE.
+func (e *JWEEncryptor) Encrypt(payload []byte) ([]byte, error) {
+	encrypted, err := jwe.Encrypt(payload, jwe.WithKey(e.alg, e.pubkey), jwe.WithContentEncryption(jwa.A256GCM))
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt data: %v", err)
+	}
+
+	return encrypted, nil
+}
+
+func keyThumbprint(privkey any) (string, error) {
+	key, err := jwk.FromRaw(privkey)
 	if err != nil {
-		return JWEDecryptor{}, fmt.Errorf("could not decode private key: %v", err)
+		return "", fmt.Errorf("could not convert private key to jwk: %v", err)
 	}
 
-	privkey, err := x509.ParsePKCS8PrivateKey(bytes)
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
 	if err != nil {
-		return JWEDecryptor{}, fmt.Errorf("could not parse private key: %v", err)
+		return "", fmt.Errorf("could not compute key thumbprint: %v", err)
 	}
 
-	return JWEDecryptor{privkey: privkey}, nil
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
 }
 
+// KeyIDs returns the thumbprints of the decryption keys, in the order they are tried.
+func (e *JWEDecryptor) KeyIDs() []string {
+	ids := make([]string, len(e.keys))
+	for i, key := range e.keys {
+		ids[i] = key.id
+	}
+
+	return ids
+}
+
+// Decrypt tries the key named by the kid header first and falls back to the other
+// keys, as the kid of Credential Store does not need to be a key thumbprint. Messages
+// using an algorithm outside of the allow-lists are rejected.
 func (e *JWEDecryptor) Decrypt(data []byte) ([]byte, error) {
-	decrypted, err := jwe.Decrypt(data, jwe.WithKey(jwa.RSA_OAEP_256, e.privkey))
+	msg, err := jwe.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data: %v", err)
+	}
+
+	algs, err := e.algorithmsOf(msg)
 	if err != nil {
 		return nil, fmt.Errorf("could not decrypt data: %v", err)
 	}
 
-	return decrypted, nil
+	var lastErr error
+	for _, alg := range algs {
+		for _, key := range e.candidates(keyIDOf(msg)) {
+			if !keySupports(key.privkey, alg) {
+				continue
+			}
+
+			decrypted, err := jwe.Decrypt(data, jwe.WithKey(alg, key.privkey))
+			if err == nil {
+				return decrypted, nil
+			}
+
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		return nil, fmt.Errorf("could not decrypt data: no decryption keys for %s", algs[0])
+	}
+
+	return nil, fmt.Errorf("could not decrypt data: %v", lastErr)
+}
+
+// algorithmsOf returns the key management algorithms of the recipients, after checking
+// them and the content encryption algorithm against the allow-lists.
+func (e *JWEDecryptor) algorithmsOf(msg *jwe.Message) ([]jwa.KeyEncryptionAlgorithm, error) {
+	enc := msg.ProtectedHeaders().ContentEncryption()
+	if !contains(e.contentAlgorithms, enc) {
+		return nil, fmt.Errorf("content encryption algorithm %q is not allowed", enc)
+	}
+
+	var algs []jwa.KeyEncryptionAlgorithm
+	for _, recipient := range msg.Recipients() {
+		algs = append(algs, recipient.Headers().Algorithm())
+	}
+
+	if len(algs) == 0 {
+		algs = append(algs, msg.ProtectedHeaders().Algorithm())
+	}
+
+	for i, alg := range algs {
+		if len(alg) == 0 {
+			algs[i] = msg.ProtectedHeaders().Algorithm()
+		}
+
+		if !contains(e.keyAlgorithms, algs[i]) {
+			return nil, fmt.Errorf("key management algorithm %q is not allowed", algs[i])
+		}
+	}
+
+	return algs, nil
+}
+
+// keySupports reports whether the key type matches the key management algorithm.
+func keySupports(privkey any, alg jwa.KeyEncryptionAlgorithm) bool {
+	switch privkey.(type) {
+	case *rsa.PrivateKey:
+		return strings.HasPrefix(alg.String(), "RSA-OAEP")
+	case *ecdsa.PrivateKey:
+		return strings.HasPrefix(alg.String(), "ECDH-ES")
+	default:
+		return false
+	}
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// candidates orders the keys so that the one matching kid is tried first.
+func (e *JWEDecryptor) candidates(kid string) []decryptionKey {
+	if len(kid) == 0 {
+		return e.keys
+	}
+
+	ordered := make([]decryptionKey, 0, len(e.keys))
+	for _, key := range e.keys {
+		if key.id == kid {
+			ordered = append(ordered, key)
+		}
+	}
+
+	for _, key := range e.keys {
+		if key.id != kid {
+			ordered = append(ordered, key)
+		}
+	}
+
+	return ordered
+}
+
+func keyIDOf(msg *jwe.Message) string {
+	if kid := msg.ProtectedHeaders().KeyID(); len(kid) != 0 {
+		return kid
+	}
+
+	for _, recipient := range msg.Recipients() {
+		if kid := recipient.Headers().KeyID(); len(kid) != 0 {
+			return kid
+		}
+	}
+
+	return ""
 }
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"os"
 	"testing"
 
@@ -37,14 +41,12 @@ func TestMain(m *testing.M) {
 	}
 
 	serviceKey := config.ServiceKey{
-		Encryption: struct {
-			ClientPrivateKey string "json:\"client_private_key\""
-		}{
+		Encryption: config.Encryption{
 			ClientPrivateKey: base64.StdEncoding.EncodeToString(privkeyBytes),
 		},
 	}
 
-	decryptor, err = NewJWEDecryptor(serviceKey)
+	decryptor, err = NewJWEDecryptor(serviceKey, JWEOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -69,22 +71,25 @@ func TestNewJWEDecryptor_InvalidKeys(t *testing.T) {
 			errorMsg: "could not parse private key",
 		},
 		{
-			name:     "not pkcs8 private key",
+			name:     "base64 encoded twice",
 			privkey:  generatePKCS1Key(),
 			errorMsg: "could not parse private key",
 		},
+		{
+			name:     "ed25519 private key",
+			privkey:  generateEd25519Key(),
+			errorMsg: "unsupported private key type ed25519.PrivateKey",
+		},
 	}
 
 	for _, d := range data {
 		serviceKey := config.ServiceKey{
-			Encryption: struct {
-				ClientPrivateKey string "json:\"client_private_key\""
-			}{
+			Encryption: config.Encryption{
 				ClientPrivateKey: base64.StdEncoding.EncodeToString(d.privkey),
 			},
 		}
 
-		decryptor, err := NewJWEDecryptor(serviceKey)
+		decryptor, err := NewJWEDecryptor(serviceKey, JWEOptions{})
 		require.Equal(t, JWEDecryptor{}, decryptor)
 		require.ErrorContains(t, err, d.errorMsg)
 	}
@@ -108,6 +113,223 @@ func TestDecrypt_InvalidData(t *testing.T) {
 	require.Nil(t, decrypted)
 }
 
+func TestNewJWEDecryptor_KeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	pkcs1 := x509.MarshalPKCS1PrivateKey(rsaKey)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: pkcs1})
+
+	data := []struct {
+		name    string
+		privkey string
+		pubkey  any
+		alg     jwa.KeyEncryptionAlgorithm
+	}{
+		{name: "pkcs8", privkey: base64.StdEncoding.EncodeToString(pkcs8), pubkey: &rsaKey.PublicKey, alg: jwa.RSA_OAEP_256},
+		{name: "pkcs1", privkey: base64.StdEncoding.EncodeToString(pkcs1), pubkey: &rsaKey.PublicKey, alg: jwa.RSA_OAEP_256},
+		{name: "sec1", privkey: base64.StdEncoding.EncodeToString(sec1), pubkey: &ecKey.PublicKey, alg: jwa.ECDH_ES_A256KW},
+		{name: "pem", privkey: string(pemKey), pubkey: &rsaKey.PublicKey, alg: jwa.RSA_OAEP_256},
+		{name: "base64 encoded pem", privkey: base64.StdEncoding.EncodeToString(pemKey), pubkey: &rsaKey.PublicKey, alg: jwa.RSA_OAEP_256},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			serviceKey := config.ServiceKey{Encryption: config.Encryption{ClientPrivateKey: d.privkey}}
+			decryptor, err := NewJWEDecryptor(serviceKey, JWEOptions{})
+			require.NoError(t, err)
+
+			encrypted, err := jwe.Encrypt(payload, jwe.WithKey(d.alg, d.pubkey))
+			require.NoError(t, err)
+
+			decrypted, err := decryptor.Decrypt(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, payload, decrypted)
+		})
+	}
+}
+
+func TestDecrypt_Algorithms(t *testing.T) {
+	restricted, err := NewJWEDecryptor(decryptorServiceKey(t), JWEOptions{
+		KeyAlgorithms:     []jwa.KeyEncryptionAlgorithm{jwa.RSA_OAEP_256},
+		ContentAlgorithms: []jwa.ContentEncryptionAlgorithm{jwa.A256GCM},
+	})
+	require.NoError(t, err)
+
+	data := []struct {
+		name      string
+		decryptor JWEDecryptor
+		alg       jwa.KeyEncryptionAlgorithm
+		enc       jwa.ContentEncryptionAlgorithm
+		errorMsg  string
+	}{
+		{name: "default", decryptor: decryptor, alg: jwa.RSA_OAEP_256, enc: jwa.A128CBC_HS256},
+		{name: "allowed", decryptor: restricted, alg: jwa.RSA_OAEP_256, enc: jwa.A256GCM},
+		{name: "sha-1 not allowed by default", decryptor: decryptor, alg: jwa.RSA_OAEP, enc: jwa.A256GCM, errorMsg: `key management algorithm "RSA-OAEP" is not allowed`},
+		{name: "rsa1_5", decryptor: decryptor, alg: jwa.RSA1_5, enc: jwa.A256GCM, errorMsg: `key management algorithm "RSA1_5" is not allowed`},
+		{name: "content algorithm not allowed", decryptor: restricted, alg: jwa.RSA_OAEP_256, enc: jwa.A128CBC_HS256, errorMsg: `content encryption algorithm "A128CBC-HS256" is not allowed`},
+		{name: "key type mismatch", decryptor: decryptor, alg: jwa.ECDH_ES, enc: jwa.A256GCM, errorMsg: "no decryption keys for ECDH-ES"},
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			var key any = pubkey
+			if d.alg == jwa.ECDH_ES {
+				key = &ecKey.PublicKey
+			}
+
+			encrypted, err := jwe.Encrypt(payload, jwe.WithKey(d.alg, key), jwe.WithContentEncryption(d.enc))
+			require.NoError(t, err)
+
+			decrypted, err := d.decryptor.Decrypt(encrypted)
+			if len(d.errorMsg) != 0 {
+				require.ErrorContains(t, err, d.errorMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, payload, decrypted)
+		})
+	}
+}
+
+func TestParseAlgorithms(t *testing.T) {
+	keyAlgs, err := ParseKeyAlgorithms([]string{"RSA-OAEP", " ECDH-ES+A256KW"})
+	require.NoError(t, err)
+	require.Equal(t, []jwa.KeyEncryptionAlgorithm{jwa.RSA_OAEP, jwa.ECDH_ES_A256KW}, keyAlgs)
+
+	_, err = ParseKeyAlgorithms([]string{"RSA1_5"})
+	require.EqualError(t, err, "unsupported key management algorithm RSA1_5")
+
+	_, err = ParseKeyAlgorithms([]string{"RSA-OAEP-512"})
+	require.EqualError(t, err, "unsupported key management algorithm RSA-OAEP-512")
+
+	contentAlgs, err := ParseContentAlgorithms([]string{"A256GCM"})
+	require.NoError(t, err)
+	require.Equal(t, []jwa.ContentEncryptionAlgorithm{jwa.A256GCM}, contentAlgs)
+
+	_, err = ParseContentAlgorithms([]string{"A256"})
+	require.EqualError(t, err, "unsupported content encryption algorithm A256")
+}
+
+func decryptorServiceKey(t *testing.T) config.ServiceKey {
+	t.Helper()
+
+	privkeyBytes, err := os.ReadFile("mock/privkey")
+	require.NoError(t, err)
+
+	return config.ServiceKey{
+		Encryption: config.Encryption{ClientPrivateKey: base64.StdEncoding.EncodeToString(privkeyBytes)},
+	}
+}
+
+// newRotatedDecryptor returns a decryptor for the mock key plus a newly generated key
+// and the public key of the new key.
+func newRotatedDecryptor(t *testing.T) (JWEDecryptor, *rsa.PublicKey) {
+	t.Helper()
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(newKey)
+	require.NoError(t, err)
+
+	privkeyBytes, err := os.ReadFile("mock/privkey")
+	require.NoError(t, err)
+
+	serviceKey := config.ServiceKey{
+		Encryption: config.Encryption{
+			ClientPrivateKey:      base64.StdEncoding.EncodeToString(privkeyBytes),
+			AdditionalPrivateKeys: []string{base64.StdEncoding.EncodeToString(der)},
+		},
+	}
+
+	rotated, err := NewJWEDecryptor(serviceKey, JWEOptions{})
+	require.NoError(t, err)
+	require.Len(t, rotated.KeyIDs(), 2)
+
+	return rotated, &newKey.PublicKey
+}
+
+func TestDecrypt_KeyRotation(t *testing.T) {
+	rotated, newPubkey := newRotatedDecryptor(t)
+
+	withKid := func(kid string) jwe.EncryptOption {
+		headers := jwe.NewHeaders()
+		require.NoError(t, headers.Set(jwe.KeyIDKey, kid))
+		return jwe.WithProtectedHeaders(headers)
+	}
+
+	data := []struct {
+		name string
+		key  any
+		opts []jwe.EncryptOption
+	}{
+		{name: "old key without kid", key: pubkey},
+		{name: "new key without kid", key: newPubkey},
+		{name: "old key with thumbprint kid", key: pubkey, opts: []jwe.EncryptOption{withKid(rotated.KeyIDs()[0])}},
+		{name: "new key with thumbprint kid", key: newPubkey, opts: []jwe.EncryptOption{withKid(rotated.KeyIDs()[1])}},
+		{name: "new key with unknown kid", key: newPubkey, opts: []jwe.EncryptOption{withKid("credstore-2023")}},
+		{name: "new key with kid of old key", key: newPubkey, opts: []jwe.EncryptOption{withKid(rotated.KeyIDs()[0])}},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			opts := append([]jwe.EncryptOption{jwe.WithKey(jwa.RSA_OAEP_256, d.key)}, d.opts...)
+			encrypted, err := jwe.Encrypt(payload, opts...)
+			require.NoError(t, err)
+
+			decrypted, err := rotated.Decrypt(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, payload, decrypted)
+		})
+	}
+
+	// the single key decryptor only knows the old key
+	encrypted, err := jwe.Encrypt(payload, jwe.WithKey(jwa.RSA_OAEP_256, newPubkey))
+	require.NoError(t, err)
+	_, err = decryptor.Decrypt(encrypted)
+	require.ErrorContains(t, err, "could not decrypt data")
+}
+
+func TestNewJWEDecryptor_ExtraKeys(t *testing.T) {
+	privkeyBytes, err := os.ReadFile("mock/privkey")
+	require.NoError(t, err)
+
+	serviceKey := config.ServiceKey{
+		Encryption: config.Encryption{ClientPrivateKey: base64.StdEncoding.EncodeToString(privkeyBytes)},
+	}
+
+	_, err = NewJWEDecryptor(serviceKey, JWEOptions{}, base64.StdEncoding.EncodeToString([]byte("foobar")))
+	require.ErrorContains(t, err, "additional key 1: could not parse private key")
+
+	extra, err := NewJWEDecryptor(serviceKey, JWEOptions{}, base64.StdEncoding.EncodeToString(privkeyBytes))
+	require.NoError(t, err)
+	require.Equal(t, []string{decryptor.KeyIDs()[0], decryptor.KeyIDs()[0]}, extra.KeyIDs())
+}
+
+func generateEd25519Key() []byte {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return der
+}
+
 func generatePKCS1Key() []byte {
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
@@ -117,3 +339,26 @@ func generatePKCS1Key() []byte {
 	privkey := base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key))
 	return []byte(privkey)
 }
+
+func TestNewJWEEncryptor(t *testing.T) {
+	encryptor, err := NewJWEEncryptor(config.ServiceKey{})
+	require.NoError(t, err)
+	require.Nil(t, encryptor)
+
+	_, err = NewJWEEncryptor(config.ServiceKey{Encryption: config.Encryption{ServerPublicKey: "Zm9vYmFy"}})
+	require.ErrorContains(t, err, "could not parse server public key")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+
+	encryptor, err = NewJWEEncryptor(config.ServiceKey{Encryption: config.Encryption{ServerPublicKey: base64.StdEncoding.EncodeToString(der)}})
+	require.NoError(t, err)
+
+	encrypted, err := encryptor.Encrypt(payload)
+	require.NoError(t, err)
+	decrypted, err := jwe.Decrypt(encrypted, jwe.WithKey(jwa.ECDH_ES_A256KW, ecKey))
+	require.NoError(t, err)
+	require.Equal(t, payload, decrypted)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// SignatureOptions enables the verification of signed Credential Store payloads.
+type SignatureOptions struct {
+	// KeyFile holds the trusted public keys, either as a JWKS or as PEM encoded public
+	// keys or certificates. Verification is disabled if empty.
+	KeyFile string
+	// Header is the response header which carries a detached JWS of the decrypted payload
+	// (RFC 7515 appendix F). Payloads of responses without the header have to be a JWS.
+	Header string
+}
+
+// JWSVerifier checks that decrypted payloads were signed with one of the trusted keys,
+// so that a party which only knows the public encryption key of the client, e.g. a
+// compromised proxy, cannot forge credentials.
+type JWSVerifier struct {
+	keys   jwk.Set
+	header string
+}
+
+// NewJWSVerifier loads the trusted keys of opts. It returns nil if no key file is set.
+func NewJWSVerifier(opts SignatureOptions) (*JWSVerifier, error) {
+	if len(opts.KeyFile) == 0 {
+		return nil, nil
+	}
+
+	keyBytes, err := os.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signature keys: %v", err)
+	}
+
+	keys, err := parseVerificationKeys(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signature keys %s: %v", opts.KeyFile, err)
+	}
+
+	return &JWSVerifier{keys: keys, header: opts.Header}, nil
+}
+
+func parseVerificationKeys(keyBytes []byte) (jwk.Set, error) {
+	var keys jwk.Set
+	var err error
+	if trimmed := bytes.TrimSpace(keyBytes); len(trimmed) != 0 && trimmed[0] == '{' {
+		keys, err = jwk.Parse(keyBytes)
+	} else {
+		keys, err = jwk.Parse(keyBytes, jwk.WithPEM(true))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("no keys found")
+	}
+
+	for i := 0; i < keys.Len(); i++ {
+		key, _ := keys.Key(i)
+		if key.KeyType() == jwa.OctetSeq {
+			return nil, fmt.Errorf("symmetric keys are not supported")
+		}
+	}
+
+	// private keys are accepted for convenience, only their public part is used
+	return jwk.PublicSetOf(keys)
+}
+
+// Verify returns the signed content of payload. It fails for payloads which are not
+// signed, or whose signature cannot be verified with any of the trusted keys.
+func (v *JWSVerifier) Verify(payload []byte, header http.Header) ([]byte, error) {
+	keySet := jws.WithKeySet(v.keys, jws.WithInferAlgorithmFromKey(true), jws.WithRequireKid(false))
+
+	if len(v.header) != 0 {
+		if detached := header.Get(v.header); len(detached) != 0 {
+			if _, err := jws.Verify([]byte(detached), keySet, jws.WithDetachedPayload(payload)); err != nil {
+				return nil, fmt.Errorf("could not verify detached signature: %v", err)
+			}
+
+			return payload, nil
+		}
+	}
+
+	if msg, err := jws.Parse(payload); err != nil || len(msg.Signatures()) == 0 {
+		return nil, fmt.Errorf("payload is not signed")
+	}
+
+	verified, err := jws.Verify(payload, keySet)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify signature: %v", err)
+	}
+
+	return verified, nil
+}
+
+// checkEntry fails if a verified payload names another credential than the one
+// requested. This only keeps a signed response from being replayed for a credential
+// of another name: the namespace and type are only compared if the payload has them,
+// older responses of the same credential pass, and list responses are not checked.
+func checkEntry(payload []byte, req request) error {
+	if len(req.name) == 0 {
+		return nil
+	}
+
+	var entry struct {
+		Namespace string `json:"namespace"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+	}
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return fmt.Errorf("could not decode signed payload: %v", err)
+	}
+
+	switch {
+	case entry.Name != req.name:
+		return fmt.Errorf("signed payload is for %s %q, but %q was requested", req.credType, entry.Name, req.name)
+	case len(entry.Namespace) != 0 && entry.Namespace != req.namespace:
+		return fmt.Errorf("signed payload is for namespace %q, but %q was requested", entry.Namespace, req.namespace)
+	case len(entry.Type) != 0 && entry.Type != req.credType:
+		return fmt.Errorf("signed payload is for a %s, but a %s was requested", entry.Type, req.credType)
+	}
+
+	return nil
+}
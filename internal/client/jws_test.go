@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/require"
+)
+
+// newSigningKey returns a signing key and the path of a PEM file with its public key.
+func newSigningKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	return key, keyFile
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, content []byte, opts ...jws.SignOption) []byte {
+	t.Helper()
+
+	signed, err := jws.Sign(content, append([]jws.SignOption{jws.WithKey(jwa.ES256, key)}, opts...)...)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func TestJWSVerifier_Verify(t *testing.T) {
+	key, keyFile := newSigningKey(t)
+	otherKey, _ := newSigningKey(t)
+
+	verifier, err := NewJWSVerifier(SignatureOptions{KeyFile: keyFile, Header: "X-Signature"})
+	require.NoError(t, err)
+
+	signed := sign(t, key, payload)
+	// flip a bit of the encoded content, which keeps the JWS well-formed
+	tampered := append([]byte{}, signed...)
+	tampered[bytes.IndexByte(tampered, '.')+2] ^= 1
+
+	detached := func(key *ecdsa.PrivateKey) http.Header {
+		return http.Header{"X-Signature": []string{string(sign(t, key, nil, jws.WithDetachedPayload(payload)))}}
+	}
+
+	data := []struct {
+		name     string
+		payload  []byte
+		header   http.Header
+		errorMsg string
+	}{
+		{name: "nested", payload: signed},
+		{name: "nested tampered", payload: tampered, errorMsg: "could not verify signature"},
+		{name: "nested foreign key", payload: sign(t, otherKey, payload), errorMsg: "could not verify signature"},
+		{name: "unsigned", payload: payload, errorMsg: "payload is not signed"},
+		{name: "unsigned json", payload: []byte(`{"id":"1","value":"secret"}`), errorMsg: "payload is not signed"},
+		{name: "detached", payload: payload, header: detached(key)},
+		{name: "detached tampered payload", payload: []byte("Hello, Mallory!"), header: detached(key), errorMsg: "could not verify detached signature"},
+		{name: "detached foreign key", payload: payload, header: detached(otherKey), errorMsg: "could not verify detached signature"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			verified, err := verifier.Verify(d.payload, d.header)
+			if len(d.errorMsg) != 0 {
+				require.ErrorContains(t, err, d.errorMsg)
+				require.Nil(t, verified)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, payload, verified)
+		})
+	}
+}
+
+func TestNewJWSVerifier(t *testing.T) {
+	verifier, err := NewJWSVerifier(SignatureOptions{})
+	require.NoError(t, err)
+	require.Nil(t, verifier)
+
+	key, _ := newSigningKey(t)
+	jwksKey, err := jwk.FromRaw(key)
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	set.AddKey(jwksKey)
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	symmetric, err := jwk.FromRaw([]byte("secret"))
+	require.NoError(t, err)
+	symmetricSet := jwk.NewSet()
+	symmetricSet.AddKey(symmetric)
+	symmetricJwks, err := json.Marshal(symmetricSet)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	write := func(name string, contents []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, contents, 0o600))
+		return path
+	}
+
+	verifier, err = NewJWSVerifier(SignatureOptions{KeyFile: write("jwks.json", jwks)})
+	require.NoError(t, err)
+	verified, err := verifier.Verify(sign(t, key, payload), nil)
+	require.NoError(t, err)
+	require.Equal(t, payload, verified)
+
+	data := []struct {
+		name     string
+		keyFile  string
+		errorMsg string
+	}{
+		{name: "missing", keyFile: filepath.Join(dir, "missing"), errorMsg: "could not read signature keys"},
+		{name: "invalid", keyFile: write("invalid", []byte("foobar")), errorMsg: "could not parse signature keys"},
+		{name: "symmetric", keyFile: write("symmetric.json", symmetricJwks), errorMsg: "symmetric keys are not supported"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := NewJWSVerifier(SignatureOptions{KeyFile: d.keyFile})
+			require.ErrorContains(t, err, d.errorMsg)
+		})
+	}
+}
+
+func TestGetPassword_Signed(t *testing.T) {
+	key, keyFile := newSigningKey(t)
+	verifier, err := NewJWSVerifier(SignatureOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+
+	expected := &PasswordCredential{ID: "1", Name: "myPassword", Value: "secret"}
+	passwordJson, err := json.Marshal(expected)
+	require.NoError(t, err)
+
+	for _, signed := range []bool{true, false} {
+		content := passwordJson
+		if signed {
+			content = sign(t, key, passwordJson)
+		}
+
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encrypted, err := jwe.Encrypt(content, jwe.WithKey(jwa.RSA_OAEP_256, pubkey))
+			require.NoError(t, err)
+			w.Write(encrypted)
+		}))
+		t.Cleanup(srv.Close)
+
+		c := newMockClient(srv)
+		c.Verifier = verifier
+
+		actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		if signed {
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+			continue
+		}
+
+		require.ErrorIs(t, err, ErrInvalidSignature)
+		require.ErrorContains(t, err, "payload is not signed")
+	}
+}
+
+func TestGetPassword_SignedSubstitution(t *testing.T) {
+	key, keyFile := newSigningKey(t)
+	verifier, err := NewJWSVerifier(SignatureOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+
+	data := []struct {
+		name     string
+		signed   string
+		errorMsg string
+	}{
+		{name: "requested credential", signed: `{"id":"1","name":"myPassword","value":"secret"}`},
+		{name: "requested credential with namespace and type", signed: `{"id":"1","namespace":"dev","type":"password","name":"myPassword","value":"secret"}`},
+		{name: "other credential", signed: `{"id":"2","name":"otherPassword","value":"other"}`, errorMsg: `signed payload is for password "otherPassword", but "myPassword" was requested`},
+		{name: "other namespace", signed: `{"id":"2","namespace":"prod","name":"myPassword","value":"other"}`, errorMsg: `signed payload is for namespace "prod", but "dev" was requested`},
+		{name: "other type", signed: `{"id":"2","type":"key","name":"myPassword","value":"other"}`, errorMsg: "signed payload is for a key, but a password was requested"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			// the response is a valid signature, replayed by a party which cannot sign
+			content := sign(t, key, []byte(d.signed))
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encrypted, err := jwe.Encrypt(content, jwe.WithKey(jwa.RSA_OAEP_256, pubkey))
+				require.NoError(t, err)
+				w.Write(encrypted)
+			}))
+			t.Cleanup(srv.Close)
+
+			c := newMockClient(srv)
+			c.Verifier = verifier
+
+			actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+			if len(d.errorMsg) != 0 {
+				require.ErrorIs(t, err, ErrInvalidSignature)
+				require.ErrorContains(t, err, d.errorMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "secret", actual.Value)
+		})
+	}
+}
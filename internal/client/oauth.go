@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+)
+
+// tokenRefreshMargin is the time before expiry at which a cached token is refreshed,
+// so that it does not expire while a request is in flight.
+const tokenRefreshMargin = 30 * time.Second
+
+// defaultTokenLifetime is assumed for tokens whose response has no expires_in.
+const defaultTokenLifetime = 5 * time.Minute
+
+// tokenFetchTimeout bounds a token refresh, which is detached from its callers.
+const tokenFetchTimeout = 30 * time.Second
+
+// tokenSource fetches OAuth2 access tokens with the client credentials grant and
+// caches them until shortly before they expire.
+type tokenSource struct {
+	creds config.OAuthCredentials
+	http  *http.Client
+	now   func() time.Time
+	// hideErrorMessages leaves the bodies of error responses out of the returned errors.
+	hideErrorMessages bool
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	// refresh is the token fetch in progress, nil if there is none.
+	refresh *tokenRefresh
+}
+
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func newTokenSource(creds config.OAuthCredentials, httpClient *http.Client) *tokenSource {
+	return &tokenSource{
+		creds: creds,
+		http:  httpClient,
+		now:   time.Now,
+	}
+}
+
+// get returns a valid access token. Concurrent callers wait for a single refresh, but
+// each of them only as long as its own context allows.
+func (s *tokenSource) get(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if len(s.token) != 0 && s.now().Before(s.expiresAt) {
+		token := s.token
+		s.mu.Unlock()
+		return token, nil
+	}
+
+	refresh := s.refresh
+	if refresh == nil {
+		refresh = &tokenRefresh{done: make(chan struct{})}
+		s.refresh = refresh
+		go s.runRefresh(refresh)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("could not fetch oauth token: %w", ctx.Err())
+	}
+}
+
+// runRefresh fetches a new token detached from the caller which started the refresh,
+// so that cancelling one caller does not fail the refresh for the others.
+func (s *tokenSource) runRefresh(refresh *tokenRefresh) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+	defer cancel()
+
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		refresh.err = fmt.Errorf("could not fetch oauth token: %w", err)
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenLifetime
+	}
+
+	margin := tokenRefreshMargin
+	if margin > expiresIn/2 {
+		margin = expiresIn / 2
+	}
+
+	s.mu.Lock()
+	if err == nil {
+		s.token = token
+		s.expiresAt = s.now().Add(expiresIn - margin)
+		refresh.token = token
+	}
+	s.refresh = nil
+	s.mu.Unlock()
+
+	close(refresh.done)
+}
+
+// invalidate drops the cached token if it is still the given one, e.g. after
+// Credential Store rejected it before it expired.
+func (s *tokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+func (s *tokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.creds.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("could not build http request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.creds.ClientID), url.QueryEscape(s.creds.ClientSecret))
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return "", 0, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, &transportError{err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, newResponseError(resp, body, s.hideErrorMessages)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("could not decode token response: %v", err)
+	}
+
+	if len(token.AccessToken) == 0 {
+		return "", 0, fmt.Errorf("token response contains no access_token")
+	}
+
+	if len(token.TokenType) != 0 && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %s", token.TokenType)
+	}
+
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenServer stands in for an OAuth2 token endpoint which issues token-1, token-2, ...
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int64) {
+	t.Helper()
+
+	var issued int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "my-client" || secret != "my-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		n := atomic.AddInt64(&issued, 1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &issued
+}
+
+// newBearerServer serves a password to requests authorized with one of the accepted tokens.
+func newBearerServer(t *testing.T, accepted ...string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, token := range accepted {
+			if r.Header.Get("Authorization") == "Bearer "+token {
+				writeJWE(t, w, PasswordCredential{Name: "myPassword", Value: "secret"})
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newOAuthClient(srv, tokenSrv *httptest.Server, secret string) *Client {
+	c := newMockClient(srv)
+	c.tokens = newTokenSource(config.OAuthCredentials{
+		TokenURL:     tokenSrv.URL,
+		ClientID:     "my-client",
+		ClientSecret: secret,
+	}, tokenSrv.Client())
+
+	return c
+}
+
+func TestOAuth_CachesToken(t *testing.T) {
+	tokenSrv, issued := newTokenServer(t, 3600)
+	c := newOAuthClient(newBearerServer(t, "token-1"), tokenSrv, "my-secret")
+
+	for i := 0; i < 3; i++ {
+		actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		require.NoError(t, err)
+		require.Equal(t, "secret", actual.Value)
+	}
+
+	require.Equal(t, int64(1), atomic.LoadInt64(issued))
+}
+
+func TestOAuth_RefreshesBeforeExpiry(t *testing.T) {
+	tokenSrv, issued := newTokenServer(t, 60)
+	c := newOAuthClient(newBearerServer(t, "token-1", "token-2"), tokenSrv, "my-secret")
+
+	now := time.Now()
+	c.tokens.now = func() time.Time { return now }
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+
+	// the token is refreshed half way through its 60s lifetime
+	now = now.Add(29 * time.Second)
+	_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(issued))
+
+	now = now.Add(2 * time.Second)
+	_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), atomic.LoadInt64(issued))
+}
+
+func TestOAuth_NoExpiresIn(t *testing.T) {
+	tokenSrv, issued := newTokenServer(t, 0)
+	c := newOAuthClient(newBearerServer(t, "token-1"), tokenSrv, "my-secret")
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(1), atomic.LoadInt64(issued))
+}
+
+func TestOAuth_SlowTokenEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	tokenSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token-1", "expires_in": 3600})
+	}))
+	t.Cleanup(tokenSrv.Close)
+	t.Cleanup(func() { close(release) })
+
+	c := newOAuthClient(newBearerServer(t, "token-1"), tokenSrv, "my-secret")
+
+	// callers give up on their own deadline instead of queueing behind the refresh
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err := c.tokens.get(ctx)
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.tokens.get(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOAuth_RetriesOnceOnUnauthorized(t *testing.T) {
+	tokenSrv, issued := newTokenServer(t, 3600)
+	c := newOAuthClient(newBearerServer(t, "token-2"), tokenSrv, "my-secret")
+
+	actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+	require.Equal(t, "secret", actual.Value)
+	require.Equal(t, int64(2), atomic.LoadInt64(issued))
+
+	c = newOAuthClient(newBearerServer(t), tokenSrv, "my-secret")
+	_, err = c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.Equal(t, int64(4), atomic.LoadInt64(issued))
+}
+
+func TestOAuth_TokenEndpointRejectsClient(t *testing.T) {
+	tokenSrv, _ := newTokenServer(t, 3600)
+	c := newOAuthClient(newBearerServer(t, "token-1"), tokenSrv, "wrong-secret")
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.ErrorContains(t, err, "could not fetch oauth token: unexpected status: got 401 Unauthorized: invalid_client")
+}
+
+func TestNewClient_OAuth(t *testing.T) {
+	tokenSrv, _ := newTokenServer(t, 3600)
+	srv := newBearerServer(t, "token-1")
+
+	serviceKey := config.ServiceKey{
+		URL:        srv.URL,
+		Encryption: decryptorServiceKey(t).Encryption,
+		OAuth: &config.OAuthCredentials{
+			TokenURL:     tokenSrv.URL,
+			ClientID:     "my-client",
+			ClientSecret: "my-secret",
+		},
+	}
+
+	// both stand-ins share the httptest certificate, so a single ca bundle trusts them
+	c, err := NewClient(serviceKey, decryptor, Options{TLS: TLSOptions{CAFile: writeCAFile(t, srv)}})
+	require.NoError(t, err)
+
+	actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+	require.Equal(t, "secret", actual.Value)
+}
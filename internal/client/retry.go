@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how failed requests to Credential Store are repeated.
+// Only transient failures are retried, see isRetryable.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt, it doubles on every further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between two attempts, including delays requested via Retry-After.
+	MaxDelay time.Duration
+}
+
+// doWithRetry runs attempt until it succeeds, fails permanently, the policy is
+// exhausted or the next backoff would not fit before the context deadline.
+func (c *Client) doWithRetry(ctx context.Context, attempt func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	for n := 1; ; n++ {
+		generation, err := c.Breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+
+		attemptCtx, cancel := c.Retry.attemptContext(ctx, n)
+		body, err := attempt(attemptCtx)
+		cancel()
+
+		c.Breaker.done(generation, breakerResultOf(ctx, err))
+		if err == nil {
+			return body, nil
+		}
+
+		if n >= c.Retry.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return nil, err
+		}
+
+		delay, ok := c.Retry.backoff(n, err)
+		if !ok {
+			return nil, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// attemptContext splits the time left until the context deadline evenly between the
+// remaining attempts, so that a hanging attempt does not use up the time of its retries.
+// Time not used by an attempt is passed on to the next ones.
+func (p RetryPolicy) attemptContext(ctx context.Context, attempt int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	remaining := p.MaxAttempts - attempt + 1
+	if !ok || remaining <= 1 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+func breakerResultOf(ctx context.Context, err error) breakerResult {
+	switch {
+	case err == nil:
+		return resultSuccess
+	case ctx.Err() != nil:
+		return resultIgnored
+	case errors.Is(err, ErrUnavailable):
+		return resultFailure
+	}
+
+	// Any other response proves that Credential Store is reachable
+	return resultSuccess
+}
+
+// backoff returns the delay before the next attempt using capped exponential
+// backoff with jitter. A Retry-After delay above MaxDelay aborts the retries.
+func (p RetryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) && respErr.RetryAfter > 0 {
+		if p.MaxDelay > 0 && respErr.RetryAfter > p.MaxDelay {
+			return 0, false
+		}
+
+		return respErr.RetryAfter, true
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0, true
+	}
+
+	// Equal jitter keeps at least half of the delay while spreading out retries of
+	// many nodes which failed at the same moment.
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1)), true
+}
+
+func isRetryable(err error) bool {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+
+		return false
+	}
+
+	var transportErr *transportError
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter supports both forms of the Retry-After header, delay in seconds and http date.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    50 * time.Millisecond,
+}
+
+func newFlakyServer(t *testing.T, failures int32, fail func(w http.ResponseWriter)) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			fail(w)
+			return
+		}
+
+		writeJWE(t, w, PasswordCredential{Name: "myPassword", Value: "secret"})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func TestGetPassword_RetriesTransientErrors(t *testing.T) {
+	data := []struct {
+		name string
+		fail func(w http.ResponseWriter)
+	}{
+		{
+			name: "service unavailable",
+			fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+		},
+		{
+			name: "too many requests",
+			fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+		},
+		{
+			name: "connection reset",
+			fail: func(w http.ResponseWriter) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				conn.Close()
+			},
+		},
+	}
+
+	for _, d := range data {
+		srv, calls := newFlakyServer(t, 2, d.fail)
+		c := newMockClient(srv)
+		c.Retry = testRetryPolicy
+
+		actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		require.NoError(t, err, d.name)
+		require.Equal(t, "secret", actual.Value, d.name)
+		require.EqualValues(t, 3, *calls, d.name)
+	}
+}
+
+func TestGetPassword_RetryExhausted(t *testing.T) {
+	srv, calls := newFlakyServer(t, 5, func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) })
+	c := newMockClient(srv)
+	c.Retry = testRetryPolicy
+
+	actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorContains(t, err, "unexpected status: got 502 Bad Gateway")
+	require.Nil(t, actual)
+	require.EqualValues(t, 3, *calls)
+}
+
+func TestGetPassword_NoRetryOnPermanentError(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) })
+	c := newMockClient(srv)
+	c.Retry = testRetryPolicy
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorContains(t, err, "unexpected status: got 404 Not Found")
+	require.EqualValues(t, 1, *calls)
+}
+
+func TestGetPassword_HonoursRetryAfter(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	c := newMockClient(srv)
+	c.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Second}
+
+	start := time.Now()
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+	require.EqualValues(t, 2, *calls)
+}
+
+func TestGetPassword_RetryAfterAboveMaxDelay(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c := newMockClient(srv)
+	c.Retry = testRetryPolicy
+
+	_, err := c.GetPassword(context.Background(), "dev", "myPassword")
+	require.ErrorContains(t, err, "unexpected status: got 503 Service Unavailable")
+	require.EqualValues(t, 1, *calls)
+}
+
+func TestGetPassword_RetryStopsAtDeadline(t *testing.T) {
+	srv, calls := newFlakyServer(t, 5, func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) })
+	c := newMockClient(srv)
+	c.Retry = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.GetPassword(ctx, "dev", "myPassword")
+	require.ErrorContains(t, err, "unexpected status: got 503 Service Unavailable")
+	require.Less(t, time.Since(start), 300*time.Millisecond)
+	require.EqualValues(t, 1, *calls)
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		expected *= time.Millisecond
+
+		delay, ok := policy.backoff(attempt+1, &ResponseError{StatusCode: http.StatusServiceUnavailable})
+		require.True(t, ok)
+		require.GreaterOrEqual(t, delay, expected/2)
+		require.LessOrEqual(t, delay, expected)
+	}
+}
+
+func TestGetPassword_SplitsDeadlineBetweenAttempts(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, func(w http.ResponseWriter) {
+		// The first attempt hangs longer than its share of the request deadline
+		time.Sleep(600 * time.Millisecond)
+	})
+	c := newMockClient(srv)
+	c.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	actual, err := c.GetPassword(ctx, "dev", "myPassword")
+	require.NoError(t, err)
+	require.Equal(t, "secret", actual.Value)
+	require.EqualValues(t, 2, *calls)
+}
+
+func TestAttemptContext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	attemptCtx, attemptCancel := policy.attemptContext(ctx, 1)
+	defer attemptCancel()
+	deadline, ok := attemptCtx.Deadline()
+	require.True(t, ok)
+	require.InDelta(t, time.Second, time.Until(deadline), float64(50*time.Millisecond))
+
+	lastCtx, lastCancel := policy.attemptContext(ctx, 4)
+	defer lastCancel()
+	lastDeadline, _ := lastCtx.Deadline()
+	parentDeadline, _ := ctx.Deadline()
+	require.Equal(t, parentDeadline, lastDeadline)
+
+	noDeadlineCtx, noDeadlineCancel := policy.attemptContext(context.Background(), 1)
+	defer noDeadlineCancel()
+	_, ok = noDeadlineCtx.Deadline()
+	require.False(t, ok)
+}
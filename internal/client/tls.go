@@ -0,0 +1,161 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+)
+
+// TLSOptions controls which Credential Store endpoints the client trusts.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to the system roots.
+	CAFile string
+	// PinnedSPKI lists base64 encoded SHA-256 hashes of subject public key infos. If set,
+	// at least one certificate of the server chain has to match one of the hashes.
+	PinnedSPKI []string
+	// MinVersion is the minimum accepted TLS version, defaults to TLS 1.2.
+	MinVersion uint16
+	// CipherSuites restricts the TLS 1.2 cipher suites, Go defaults are used if empty.
+	CipherSuites []uint16
+}
+
+// ParseTLSVersion converts the versions 1.2 and 1.3 to their tls package constants.
+// Older versions are rejected, TLS 1.2 is the floor.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("unsupported tls version %s", version)
+}
+
+// ParseCipherSuites converts IANA cipher suite names to their IDs. Insecure cipher suites
+// are rejected, and so are TLS 1.3 suites, which crypto/tls does not allow to configure.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	supported := make(map[string]uint16)
+	tls13 := make(map[string]bool)
+	for _, suite := range tls.CipherSuites() {
+		if supportsTLS12(suite) {
+			supported[suite.Name] = suite.ID
+		} else {
+			tls13[suite.Name] = true
+		}
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if tls13[name] {
+			return nil, fmt.Errorf("cipher suite %s is a TLS 1.3 cipher suite, which cannot be configured", name)
+		}
+
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %s", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func supportsTLS12(suite *tls.CipherSuite) bool {
+	for _, version := range suite.SupportedVersions {
+		if version == tls.VersionTLS12 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newTLSConfig(serviceKey config.ServiceKey, opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: opts.CipherSuites,
+	}
+
+	// OAuth bindings authenticate with bearer tokens instead of a client certificate
+	if serviceKey.OAuth == nil {
+		cert, err := tls.X509KeyPair([]byte(serviceKey.Certificate), []byte(serviceKey.Key))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse x509 key pair: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var err error
+
+	if opts.MinVersion > tlsConfig.MinVersion {
+		tlsConfig.MinVersion = opts.MinVersion
+	}
+
+	if len(opts.CAFile) != 0 {
+		tlsConfig.RootCAs, err = loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.PinnedSPKI) != 0 {
+		tlsConfig.VerifyConnection, err = verifyPinnedSPKI(opts.PinnedSPKI)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca bundle: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("could not parse ca bundle %s: no certificates found", caFile)
+	}
+
+	return pool, nil
+}
+
+// verifyPinnedSPKI runs after the regular chain verification and additionally requires
+// one of the presented certificates to carry a pinned public key.
+func verifyPinnedSPKI(pins []string) (func(tls.ConnectionState) error, error) {
+	pinned := make(map[[sha256.Size]byte]bool, len(pins))
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pin))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %s: expected base64 encoded sha256 hash", pin)
+		}
+
+		pinned[*(*[sha256.Size]byte)(hash)] = true
+	}
+
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			if pinned[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("server certificate chain does not match any pinned public key")
+	}, nil
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newPasswordServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJWE(t, w, PasswordCredential{Name: "myPassword", Value: "secret"})
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newServiceKey returns a service key with a self-signed client certificate.
+func newServiceKey(t *testing.T, url string) config.ServiceKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "credstore-csi-provider"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return config.ServiceKey{
+		URL:         url,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})),
+		Encryption:  decryptorServiceKey(t).Encryption,
+	}
+}
+
+func writeCAFile(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(path, pemBytes, 0600))
+
+	return path
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestNewClient_TLS(t *testing.T) {
+	srv := newPasswordServer(t)
+	caFile := writeCAFile(t, srv)
+
+	data := []struct {
+		name     string
+		opts     TLSOptions
+		errorMsg string
+	}{
+		{
+			name:     "untrusted server",
+			opts:     TLSOptions{},
+			errorMsg: "certificate signed by unknown authority",
+		},
+		{
+			name: "custom ca bundle",
+			opts: TLSOptions{CAFile: caFile},
+		},
+		{
+			name: "matching spki pin",
+			opts: TLSOptions{CAFile: caFile, PinnedSPKI: []string{spkiPin(srv.Certificate())}},
+		},
+		{
+			name:     "mismatching spki pin",
+			opts:     TLSOptions{CAFile: caFile, PinnedSPKI: []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}},
+			errorMsg: "server certificate chain does not match any pinned public key",
+		},
+		{
+			name:     "minimum tls version not supported by server",
+			opts:     TLSOptions{CAFile: caFile, MinVersion: tls.VersionTLS13},
+			errorMsg: "protocol version",
+		},
+		{
+			name: "allowed cipher suite",
+			opts: TLSOptions{CAFile: caFile, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}},
+		},
+		{
+			name:     "no common cipher suite",
+			opts:     TLSOptions{CAFile: caFile, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}},
+			errorMsg: "handshake failure",
+		},
+	}
+
+	for _, d := range data {
+		c, err := NewClient(newServiceKey(t, srv.URL), decryptor, Options{TLS: d.opts})
+		require.NoError(t, err, d.name)
+
+		actual, err := c.GetPassword(context.Background(), "dev", "myPassword")
+		if len(d.errorMsg) != 0 {
+			require.ErrorContains(t, err, d.errorMsg, d.name)
+			continue
+		}
+
+		require.NoError(t, err, d.name)
+		require.Equal(t, "secret", actual.Value, d.name)
+	}
+}
+
+func TestNewClient_InvalidTLSOptions(t *testing.T) {
+	data := []struct {
+		name     string
+		opts     TLSOptions
+		errorMsg string
+	}{
+		{
+			name:     "missing ca bundle",
+			opts:     TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+			errorMsg: "could not read ca bundle",
+		},
+		{
+			name:     "invalid spki pin",
+			opts:     TLSOptions{PinnedSPKI: []string{"foobar"}},
+			errorMsg: "invalid spki pin foobar",
+		},
+	}
+
+	for _, d := range data {
+		c, err := NewClient(newServiceKey(t, "https://localhost"), decryptor, Options{TLS: d.opts})
+		require.ErrorContains(t, err, d.errorMsg, d.name)
+		require.Nil(t, c, d.name)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	version, err := ParseTLSVersion("1.3")
+	require.NoError(t, err)
+	require.EqualValues(t, tls.VersionTLS13, version)
+
+	for _, version := range []string{"1.0", "1.1", "2.0"} {
+		_, err = ParseTLSVersion(version)
+		require.EqualError(t, err, "unsupported tls version "+version)
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	_, err = ParseCipherSuites([]string{" TLS_AES_128_GCM_SHA256"})
+	require.EqualError(t, err, "cipher suite TLS_AES_128_GCM_SHA256 is a TLS 1.3 cipher suite, which cannot be configured")
+
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	require.EqualError(t, err, "unsupported cipher suite TLS_RSA_WITH_RC4_128_SHA")
+}
@@ -1,32 +1,131 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ServiceKey struct {
-	URL         string `json:"url"`
-	Certificate string `json:"certificate"`
-	Key         string `json:"key"`
-	Encryption  struct {
-		ClientPrivateKey string `json:"client_private_key"`
-	} `json:"encryption"`
+	URL         string     `json:"url"`
+	Certificate string     `json:"certificate"`
+	Key         string     `json:"key"`
+	Encryption  Encryption `json:"encryption"`
+	// OAuth is set for bindings which authenticate with OAuth2 client credentials instead of mTLS.
+	OAuth *OAuthCredentials `json:"oauth,omitempty"`
+}
+
+// Encryption holds the keys for decrypting payloads. It is empty for Credential Store
+// instances with payload encryption disabled.
+type Encryption struct {
+	ClientPrivateKey string `json:"client_private_key"`
+	// AdditionalPrivateKeys are accepted besides ClientPrivateKey while the encryption key pair is rotated.
+	AdditionalPrivateKeys []string `json:"additional_private_keys,omitempty"`
+	// ServerPublicKey encrypts the bodies of requests which create credentials.
+	ServerPublicKey string `json:"server_public_key,omitempty"`
+}
+
+type OAuthCredentials struct {
+	TokenURL     string `json:"token_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
 }
 
 type Parameters struct {
 	Permission  int32
 	Credentials []Credential
+	// ServiceKey names the service key used for the mount, empty means the default key.
+	ServiceKey string
+	// PodServiceKey is the service key supplied through the nodePublishSecretRef of the pod, if any.
+	PodServiceKey *ServiceKey
+	Pod           Pod
+	// ServiceAccountTokens are the tokens the driver requested for the pod, keyed by audience.
+	ServiceAccountTokens map[string]string
+	// FetchTimeout bounds fetching a single credential including retries, zero means provider default.
+	FetchTimeout time.Duration
+	// MountTimeout bounds the whole mount request, zero means provider default.
+	MountTimeout time.Duration
+}
+
+// Pod identifies the pod of a mount request as passed by the driver.
+type Pod struct {
+	Namespace      string
+	Name           string
+	ServiceAccount string
 }
 
 type Credential struct {
 	Namespace string `yaml:"namespace,omitempty"`
 	Type      string `yaml:"type,omitempty"`
 	Name      string `yaml:"name,omitempty"`
-	FileName  string `yaml:"fileName,omitempty"`
-	Mode      *int32 `yaml:"mode,omitempty"`
+	// NamePattern turns the credential into a selector which mounts every matching credential
+	// of the namespace, see ExpandSelector. FileName is then a template for the file names.
+	NamePattern string `yaml:"namePattern,omitempty"`
+	FileName    string `yaml:"fileName,omitempty"`
+	Mode        *int32 `yaml:"mode,omitempty"`
+	Field       string `yaml:"field,omitempty"`
+	Files       []File `yaml:"files,omitempty"`
+	// GenerateIfMissing creates the credential with a generated value if it does not exist yet.
+	GenerateIfMissing *Generate `yaml:"generateIfMissing,omitempty"`
+}
+
+// Generate describes the value generated for a missing credential. Length and Charset
+// apply to passwords, KeySize and Format to keys.
+type Generate struct {
+	Length  int    `yaml:"length,omitempty"`
+	Charset string `yaml:"charset,omitempty"`
+	// KeySize is the size of the key in bits.
+	KeySize int    `yaml:"keySize,omitempty"`
+	Format  string `yaml:"format,omitempty"`
+}
+
+// File maps a single field of a credential to a destination file. Credentials which
+// declare files are expanded into one Credential per file during parsing.
+type File struct {
+	FileName string `yaml:"fileName,omitempty"`
+	Field    string `yaml:"field,omitempty"`
+	Mode     *int32 `yaml:"mode,omitempty"`
+}
+
+// ServiceKeySecret is the entry of a nodePublishSecretRef secret which holds a service key.
+const ServiceKeySecret = "service-key.json"
+
+// ErrInvalidCredentials is returned for credential entries which turn out to be invalid
+// only after their selectors were expanded.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Charsets maps the charsets of generated passwords to their characters.
+var Charsets = map[string]string{
+	"alphanumeric": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"numeric":      "0123456789",
+	"hex":          "0123456789abcdef",
+	"printable":    "!\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~",
+}
+
+// KeySizes lists the supported sizes in bits of generated keys by format. The first
+// size is the default.
+var KeySizes = map[string][]int{
+	"AES": {256, 128, 192},
+	"RSA": {2048, 3072, 4096},
+	"EC":  {256, 384, 521},
+}
+
+const (
+	defaultPasswordLength = 32
+	minPasswordLength     = 8
+	maxPasswordLength     = 1024
+)
+
+// credentialFields lists the fields which can be mounted for each credential type.
+var credentialFields = map[string][]string{
+	"password":    {"value", "username", "metadata", "modifiedAt"},
+	"key":         {"value", "username", "metadata", "modifiedAt", "format"},
+	"certificate": {"value", "key", "chain", "metadata", "modifiedAt"},
 }
 
 func ParseServiceKey(jsonBytes []byte) (ServiceKey, error) {
@@ -35,9 +134,77 @@ func ParseServiceKey(jsonBytes []byte) (ServiceKey, error) {
 		return ServiceKey{}, fmt.Errorf("could not parse service key: %v", err)
 	}
 
+	type requiredField struct{ name, value string }
+
+	required := []requiredField{{"url", serviceKey.URL}}
+	if serviceKey.OAuth != nil {
+		required = append(required,
+			requiredField{"oauth.token_url", serviceKey.OAuth.TokenURL},
+			requiredField{"oauth.client_id", serviceKey.OAuth.ClientID},
+			requiredField{"oauth.client_secret", serviceKey.OAuth.ClientSecret},
+		)
+	} else {
+		required = append(required,
+			requiredField{"certificate", serviceKey.Certificate},
+			requiredField{"key", serviceKey.Key},
+		)
+	}
+
+	if len(serviceKey.Encryption.AdditionalPrivateKeys) != 0 {
+		required = append(required, requiredField{"encryption.client_private_key", serviceKey.Encryption.ClientPrivateKey})
+	}
+
+	for _, field := range required {
+		if len(field.value) == 0 {
+			return ServiceKey{}, fmt.Errorf("service key %s cannot be empty", field.name)
+		}
+	}
+
 	return serviceKey, nil
 }
 
+// EncryptionEnabled reports whether payloads of the Credential Store instance are
+// encrypted, i.e. whether the service key has a client private key.
+func (k ServiceKey) EncryptionEnabled() bool {
+	return len(k.Encryption.ClientPrivateKey) != 0
+}
+
+// ParseSecrets extracts the service key from the nodePublishSecretRef secret of a mount
+// request. It returns nil if the secret does not contain a ServiceKeySecret entry.
+func ParseSecrets(secrets string) (*ServiceKey, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+
+	var data map[string]string
+	if err := json.Unmarshal([]byte(secrets), &data); err != nil {
+		return nil, fmt.Errorf("could not parse secrets field: %v", err)
+	}
+
+	serviceKeyJson, ok := data[ServiceKeySecret]
+	if !ok {
+		return nil, nil
+	}
+
+	serviceKey, err := ParseServiceKey([]byte(serviceKeyJson))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in nodePublishSecretRef: %v", ServiceKeySecret, err)
+	}
+
+	return &serviceKey, nil
+}
+
+// Fingerprint identifies the service key including its secrets, so that two keys share
+// a fingerprint only if they are interchangeable. A key which merely names the instance
+// and binding of another one, e.g. with a junk private key, gets a different fingerprint.
+func (k ServiceKey) Fingerprint() string {
+	// json.Marshal encodes the fields in declaration order and cannot fail for a ServiceKey
+	canonical, _ := json.Marshal(k)
+
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:])
+}
+
 func ParseParameters(attributes, permission string) (Parameters, error) {
 	params := Parameters{}
 
@@ -45,45 +212,185 @@ func ParseParameters(attributes, permission string) (Parameters, error) {
 		return Parameters{}, fmt.Errorf("could not parse permission field: %v", err)
 	}
 
+	var attrs map[string]string
+	if err := json.Unmarshal([]byte(attributes), &attrs); err != nil {
+		return Parameters{}, fmt.Errorf("could not parse attributes field: %v", err)
+	}
+
 	var err error
-	params.Credentials, err = parseCredentials(attributes)
+	params.Credentials, err = parseCredentials(attrs["credentials"])
 	if err != nil {
 		return Parameters{}, err
 	}
 
-	if err = validateCredentials(params); err != nil {
+	params.ServiceKey = attrs["serviceKey"]
+	params.Pod = Pod{
+		Namespace:      attrs["csi.storage.k8s.io/pod.namespace"],
+		Name:           attrs["csi.storage.k8s.io/pod.name"],
+		ServiceAccount: attrs["csi.storage.k8s.io/serviceAccount.name"],
+	}
+
+	params.ServiceAccountTokens, err = parseServiceAccountTokens(attrs["csi.storage.k8s.io/serviceAccount.tokens"])
+	if err != nil {
 		return Parameters{}, err
 	}
 
-	return params, nil
-}
+	params.FetchTimeout, err = parseTimeout(attrs, "fetchTimeout")
+	if err != nil {
+		return Parameters{}, err
+	}
+
+	params.MountTimeout, err = parseTimeout(attrs, "mountTimeout")
+	if err != nil {
+		return Parameters{}, err
+	}
 
-func parseCredentials(attributesStr string) ([]Credential, error) {
-	var attributes map[string]string
-	if err := json.Unmarshal([]byte(attributesStr), &attributes); err != nil {
-		return nil, fmt.Errorf("could not parse attributes field: %v", err)
+	if err = ValidateCredentials(params.Credentials); err != nil {
+		return Parameters{}, err
 	}
 
+	return params, nil
+}
+
+func parseCredentials(credsYaml string) ([]Credential, error) {
 	var creds []Credential
-	credsYaml := attributes["credentials"]
 	if err := yaml.Unmarshal([]byte(credsYaml), &creds); err != nil {
 		return nil, fmt.Errorf("could not parse credentials field: %v", err)
 	}
 
+	creds, err := expandFiles(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cred := range creds {
+		if cred.GenerateIfMissing != nil {
+			gen := cred.GenerateIfMissing.withDefaults(cred.Type)
+			creds[i].GenerateIfMissing = &gen
+		}
+	}
+
 	return creds, nil
 }
 
-func validateCredentials(params Parameters) error {
+func (g Generate) withDefaults(credType string) Generate {
+	switch credType {
+	case "password":
+		if g.Length == 0 {
+			g.Length = defaultPasswordLength
+		}
+
+		if len(g.Charset) == 0 {
+			g.Charset = "alphanumeric"
+		}
+	case "key":
+		if len(g.Format) == 0 {
+			g.Format = "AES"
+		}
+
+		if sizes, ok := KeySizes[g.Format]; ok && g.KeySize == 0 {
+			g.KeySize = sizes[0]
+		}
+	}
+
+	return g
+}
+
+func parseServiceAccountTokens(tokensJson string) (map[string]string, error) {
+	if len(tokensJson) == 0 {
+		return nil, nil
+	}
+
+	var tokens map[string]struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(tokensJson), &tokens); err != nil {
+		return nil, fmt.Errorf("could not parse service account tokens: %v", err)
+	}
+
+	byAudience := make(map[string]string, len(tokens))
+	for audience, token := range tokens {
+		byAudience[audience] = token.Token
+	}
+
+	return byAudience, nil
+}
+
+func parseTimeout(attributes map[string]string, name string) (time.Duration, error) {
+	value, ok := attributes[name]
+	if !ok {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, e.g. 10s", name)
+	}
+
+	return timeout, nil
+}
+
+func expandFiles(creds []Credential) ([]Credential, error) {
+	var expanded []Credential
+	for _, cred := range creds {
+		if len(cred.Files) == 0 {
+			expanded = append(expanded, cred)
+			continue
+		}
+
+		if len(cred.FileName) != 0 || len(cred.Field) != 0 {
+			return nil, fmt.Errorf("credential %s cannot set fileName or field together with files", cred.Name)
+		}
+
+		for _, file := range cred.Files {
+			c := cred
+			c.Files = nil
+			c.FileName = file.FileName
+			c.Field = file.Field
+			if file.Mode != nil {
+				c.Mode = file.Mode
+			}
+
+			expanded = append(expanded, c)
+		}
+	}
+
+	return expanded, nil
+}
+
+// ValidateCredentials checks the credential entries of a mount request. Selectors are
+// validated as far as possible and their file names are checked for uniqueness only
+// after they were expanded.
+func ValidateCredentials(creds []Credential) error {
 	fileNames := make(map[string]bool)
-	for _, cred := range params.Credentials {
+	for _, cred := range creds {
 		if len(cred.Namespace) == 0 {
 			return fmt.Errorf("credential namespace cannot be empty")
 		}
 
-		if len(cred.Type) == 0 || (cred.Type != "password" && cred.Type != "key") {
+		fields, ok := credentialFields[cred.Type]
+		if !ok {
 			return fmt.Errorf("credential type cannot be empty or invalid")
 		}
 
+		if len(cred.Field) != 0 && !contains(fields, cred.Field) {
+			return fmt.Errorf("credential field %s is not supported for type %s", cred.Field, cred.Type)
+		}
+
+		if cred.GenerateIfMissing != nil {
+			if err := validateGenerate(cred); err != nil {
+				return err
+			}
+		}
+
+		if cred.IsSelector() {
+			if err := validateSelector(cred); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		if len(cred.Name) == 0 {
 			return fmt.Errorf("credential name cannot be empty")
 		}
@@ -101,3 +408,57 @@ func validateCredentials(params Parameters) error {
 
 	return nil
 }
+
+func validateGenerate(cred Credential) error {
+	gen := cred.GenerateIfMissing
+	if cred.IsSelector() {
+		return fmt.Errorf("credential generateIfMissing cannot be set for selectors")
+	}
+
+	switch cred.Type {
+	case "password":
+		if gen.KeySize != 0 || len(gen.Format) != 0 {
+			return fmt.Errorf("credential generateIfMissing keySize and format only apply to keys")
+		}
+
+		if gen.Length < minPasswordLength || gen.Length > maxPasswordLength {
+			return fmt.Errorf("credential generateIfMissing length must be between %d and %d", minPasswordLength, maxPasswordLength)
+		}
+
+		if _, ok := Charsets[gen.Charset]; !ok {
+			return fmt.Errorf("credential generateIfMissing charset %s is not supported", gen.Charset)
+		}
+	case "key":
+		if gen.Length != 0 || len(gen.Charset) != 0 {
+			return fmt.Errorf("credential generateIfMissing length and charset only apply to passwords")
+		}
+
+		sizes, ok := KeySizes[gen.Format]
+		if !ok {
+			return fmt.Errorf("credential generateIfMissing format %s is not supported", gen.Format)
+		}
+
+		supported := false
+		for _, size := range sizes {
+			supported = supported || size == gen.KeySize
+		}
+
+		if !supported {
+			return fmt.Errorf("credential generateIfMissing keySize %d is not supported for %s keys", gen.KeySize, gen.Format)
+		}
+	default:
+		return fmt.Errorf("credential generateIfMissing is not supported for type %s", cred.Type)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
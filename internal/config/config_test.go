@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -28,6 +29,19 @@ const (
   type: key
   namespace: dev
   fileName: key-no-mode.der
+- name: myCert
+  type: certificate
+  namespace: dev
+  fileName: cert.pem
+- name: myDatabase
+  type: password
+  namespace: dev
+  mode: 0400
+  files:
+  - fileName: db-user
+    field: username
+    mode: 0444
+  - fileName: db-password
 `
 	noNameCredential = `
 - type: password
@@ -59,6 +73,24 @@ const (
   namespace: dev
 `
 
+	invalidFieldCredential = `
+- name: myPassword
+  type: password
+  namespace: dev
+  fileName: password.txt
+  field: chain
+`
+
+	filesWithFileNameCredential = `
+- name: myPassword
+  type: password
+  namespace: dev
+  fileName: password.txt
+  files:
+  - fileName: user.txt
+    field: username
+`
+
 	duplicateFileNames = `
 - name: myPassword
   type: password
@@ -85,10 +117,13 @@ func TestParse(t *testing.T) {
 			expected: Parameters{
 				Permission: 420,
 				Credentials: []Credential{
-					{"dev", "password", "myPassword", "password.txt", modePtr(0644)},
-					{"dev", "key", "myKey", "key.der", modePtr(0400)},
-					{"dev", "key", "myKeyDec", "key-dec.der", modePtr(0644)},
-					{"dev", "key", "myKeyNoMode", "key-no-mode.der", nil},
+					{Namespace: "dev", Type: "password", Name: "myPassword", FileName: "password.txt", Mode: modePtr(0644)},
+					{Namespace: "dev", Type: "key", Name: "myKey", FileName: "key.der", Mode: modePtr(0400)},
+					{Namespace: "dev", Type: "key", Name: "myKeyDec", FileName: "key-dec.der", Mode: modePtr(0644)},
+					{Namespace: "dev", Type: "key", Name: "myKeyNoMode", FileName: "key-no-mode.der"},
+					{Namespace: "dev", Type: "certificate", Name: "myCert", FileName: "cert.pem"},
+					{Namespace: "dev", Type: "password", Name: "myDatabase", FileName: "db-user", Mode: modePtr(0444), Field: "username"},
+					{Namespace: "dev", Type: "password", Name: "myDatabase", FileName: "db-password", Mode: modePtr(0400)},
 				},
 			},
 		},
@@ -150,6 +185,18 @@ func TestParse_Errors(t *testing.T) {
 			attributes: map[string]string{"credentials": noFileNameCredential},
 			errorMsg:   "credential file name cannot be empty",
 		},
+		{
+			name:       "invalid field",
+			permission: "420",
+			attributes: map[string]string{"credentials": invalidFieldCredential},
+			errorMsg:   "credential field chain is not supported for type password",
+		},
+		{
+			name:       "files with file name",
+			permission: "420",
+			attributes: map[string]string{"credentials": filesWithFileNameCredential},
+			errorMsg:   "credential myPassword cannot set fileName or field together with files",
+		},
 		{
 			name:       "duplicate file name",
 			permission: "420",
@@ -168,6 +215,262 @@ func TestParse_Errors(t *testing.T) {
 	}
 }
 
+func TestParse_GenerateIfMissing(t *testing.T) {
+	creds := `
+- name: dbPassword
+  type: password
+  namespace: dev
+  fileName: db-password
+  generateIfMissing: {}
+- name: pin
+  type: password
+  namespace: dev
+  fileName: pin
+  generateIfMissing: {length: 8, charset: numeric}
+- name: signingKey
+  type: key
+  namespace: dev
+  files:
+  - fileName: signing.key
+  - fileName: signing.format
+    field: format
+  generateIfMissing: {format: EC}
+`
+	attributes, err := json.Marshal(map[string]string{"credentials": creds})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, &Generate{Length: 32, Charset: "alphanumeric"}, actual.Credentials[0].GenerateIfMissing)
+	require.Equal(t, &Generate{Length: 8, Charset: "numeric"}, actual.Credentials[1].GenerateIfMissing)
+	require.Equal(t, &Generate{KeySize: 256, Format: "EC"}, actual.Credentials[2].GenerateIfMissing)
+	require.Equal(t, actual.Credentials[2].GenerateIfMissing, actual.Credentials[3].GenerateIfMissing)
+	require.Len(t, Charsets["printable"], 94)
+}
+
+func TestParse_GenerateIfMissingErrors(t *testing.T) {
+	data := []struct {
+		name     string
+		cred     string
+		errorMsg string
+	}{
+		{name: "certificate", cred: "type: certificate, generateIfMissing: {}", errorMsg: "credential generateIfMissing is not supported for type certificate"},
+		{name: "selector", cred: "type: password, namePattern: 'db-*', generateIfMissing: {}", errorMsg: "credential generateIfMissing cannot be set for selectors"},
+		{name: "short password", cred: "type: password, generateIfMissing: {length: 4}", errorMsg: "credential generateIfMissing length must be between 8 and 1024"},
+		{name: "unknown charset", cred: "type: password, generateIfMissing: {charset: emoji}", errorMsg: "credential generateIfMissing charset emoji is not supported"},
+		{name: "password key size", cred: "type: password, generateIfMissing: {keySize: 256}", errorMsg: "credential generateIfMissing keySize and format only apply to keys"},
+		{name: "key length", cred: "type: key, generateIfMissing: {length: 32}", errorMsg: "credential generateIfMissing length and charset only apply to passwords"},
+		{name: "unknown format", cred: "type: key, generateIfMissing: {format: DSA}", errorMsg: "credential generateIfMissing format DSA is not supported"},
+		{name: "weak rsa key", cred: "type: key, generateIfMissing: {format: RSA, keySize: 1024}", errorMsg: "credential generateIfMissing keySize 1024 is not supported for RSA keys"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			creds := "- {name: myCred, namespace: dev, fileName: cred, " + d.cred + "}"
+			attributes, err := json.Marshal(map[string]string{"credentials": creds})
+			require.NoError(t, err)
+
+			_, err = ParseParameters(string(attributes), "420")
+			require.EqualError(t, err, d.errorMsg)
+		})
+	}
+}
+
+func TestParse_Timeouts(t *testing.T) {
+	attributes, err := json.Marshal(map[string]string{
+		"credentials":  credentials,
+		"fetchTimeout": "5s",
+		"mountTimeout": "1m30s",
+	})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, actual.FetchTimeout)
+	require.Equal(t, 90*time.Second, actual.MountTimeout)
+}
+
+func TestParse_ServiceKey(t *testing.T) {
+	attributes, err := json.Marshal(map[string]string{
+		"credentials": credentials,
+		"serviceKey":  "team-a",
+	})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, "team-a", actual.ServiceKey)
+}
+
+func TestParse_Pod(t *testing.T) {
+	attributes, err := json.Marshal(map[string]string{
+		"credentials":                            credentials,
+		"csi.storage.k8s.io/pod.namespace":       "team-a",
+		"csi.storage.k8s.io/pod.name":            "app-0",
+		"csi.storage.k8s.io/serviceAccount.name": "backend",
+	})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, Pod{Namespace: "team-a", Name: "app-0", ServiceAccount: "backend"}, actual.Pod)
+}
+
+func TestParse_ServiceAccountTokens(t *testing.T) {
+	attributes, err := json.Marshal(map[string]string{
+		"credentials": credentials,
+		"csi.storage.k8s.io/serviceAccount.tokens": `{"credstore":{"token":"eyJ...","expirationTimestamp":"2023-04-01T11:00:00Z"}}`,
+	})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"credstore": "eyJ..."}, actual.ServiceAccountTokens)
+
+	attributes, err = json.Marshal(map[string]string{
+		"credentials": credentials,
+		"csi.storage.k8s.io/serviceAccount.tokens": "[]",
+	})
+	require.NoError(t, err)
+
+	_, err = ParseParameters(string(attributes), "420")
+	require.ErrorContains(t, err, "could not parse service account tokens")
+}
+
+func TestParse_InvalidTimeouts(t *testing.T) {
+	data := []struct {
+		name       string
+		attributes map[string]string
+		errorMsg   string
+	}{
+		{
+			name:       "invalid fetch timeout",
+			attributes: map[string]string{"fetchTimeout": "5"},
+			errorMsg:   "fetchTimeout must be a positive duration, e.g. 10s",
+		},
+		{
+			name:       "negative mount timeout",
+			attributes: map[string]string{"mountTimeout": "-1s"},
+			errorMsg:   "mountTimeout must be a positive duration, e.g. 10s",
+		},
+	}
+
+	for _, d := range data {
+		jsonStr, err := json.Marshal(d.attributes)
+		require.NoError(t, err, d.name)
+
+		actual, err := ParseParameters(string(jsonStr), "420")
+		require.EqualError(t, err, d.errorMsg, d.name)
+		require.Equal(t, Parameters{}, actual, d.name)
+	}
+}
+
 func modePtr(mode int32) *int32 {
 	return &mode
 }
+
+func TestParseServiceKey(t *testing.T) {
+	serviceKey, err := ParseServiceKey([]byte(`{"url":"https://credstore","certificate":"cert","key":"key","encryption":{"client_private_key":"priv"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "https://credstore", serviceKey.URL)
+	require.Equal(t, "priv", serviceKey.Encryption.ClientPrivateKey)
+}
+
+func TestParseServiceKey_EncryptionDisabled(t *testing.T) {
+	serviceKey, err := ParseServiceKey([]byte(`{"url":"https://credstore","certificate":"cert","key":"key"}`))
+	require.NoError(t, err)
+	require.False(t, serviceKey.EncryptionEnabled())
+
+	serviceKey.Encryption.ClientPrivateKey = "priv"
+	require.True(t, serviceKey.EncryptionEnabled())
+}
+
+func TestParseServiceKey_OAuth(t *testing.T) {
+	serviceKey, err := ParseServiceKey([]byte(`{"url":"https://credstore","oauth":{"token_url":"https://uaa/oauth/token","client_id":"id","client_secret":"secret"},"encryption":{"client_private_key":"priv"}}`))
+	require.NoError(t, err)
+	require.Equal(t, &OAuthCredentials{TokenURL: "https://uaa/oauth/token", ClientID: "id", ClientSecret: "secret"}, serviceKey.OAuth)
+
+	other := serviceKey
+	other.OAuth = &OAuthCredentials{TokenURL: "https://uaa/oauth/token", ClientID: "other", ClientSecret: "secret"}
+	require.NotEqual(t, serviceKey.Fingerprint(), other.Fingerprint())
+}
+
+func TestServiceKey_Fingerprint(t *testing.T) {
+	serviceKey := ServiceKey{
+		URL:         "https://credstore",
+		Certificate: "cert",
+		Key:         "key",
+		Encryption:  Encryption{ClientPrivateKey: "priv", ServerPublicKey: "pub"},
+		OAuth:       &OAuthCredentials{TokenURL: "https://uaa/oauth/token", ClientID: "id", ClientSecret: "secret"},
+	}
+	require.Equal(t, serviceKey.Fingerprint(), serviceKey.Fingerprint())
+
+	data := []struct {
+		name   string
+		modify func(k *ServiceKey)
+	}{
+		{name: "url", modify: func(k *ServiceKey) { k.URL = "https://other" }},
+		{name: "certificate", modify: func(k *ServiceKey) { k.Certificate = "other" }},
+		{name: "private key", modify: func(k *ServiceKey) { k.Key = "junk" }},
+		{name: "client secret", modify: func(k *ServiceKey) {
+			k.OAuth = &OAuthCredentials{TokenURL: "https://uaa/oauth/token", ClientID: "id", ClientSecret: "junk"}
+		}},
+		{name: "client private key", modify: func(k *ServiceKey) { k.Encryption.ClientPrivateKey = "junk" }},
+		{name: "additional private keys", modify: func(k *ServiceKey) { k.Encryption.AdditionalPrivateKeys = []string{"junk"} }},
+		{name: "server public key", modify: func(k *ServiceKey) { k.Encryption.ServerPublicKey = "junk" }},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			other := serviceKey
+			d.modify(&other)
+			require.NotEqual(t, serviceKey.Fingerprint(), other.Fingerprint())
+		})
+	}
+}
+
+func TestParseServiceKey_Invalid(t *testing.T) {
+	data := []struct {
+		name     string
+		json     string
+		expected string
+	}{
+		{name: "malformed", json: `{"url":`, expected: "could not parse service key"},
+		{name: "no url", json: `{"certificate":"cert","key":"key","encryption":{"client_private_key":"priv"}}`, expected: "service key url cannot be empty"},
+		{name: "oauth without secret", json: `{"url":"https://credstore","oauth":{"token_url":"https://uaa/oauth/token","client_id":"id"},"encryption":{"client_private_key":"priv"}}`, expected: "service key oauth.client_secret cannot be empty"},
+		{name: "only additional private keys", json: `{"url":"https://credstore","certificate":"cert","key":"key","encryption":{"additional_private_keys":["priv"]}}`, expected: "service key encryption.client_private_key cannot be empty"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := ParseServiceKey([]byte(d.json))
+			require.ErrorContains(t, err, d.expected)
+		})
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+	serviceKey := `{"url":"https://credstore","certificate":"cert","key":"key","encryption":{"client_private_key":"priv"}}`
+	secrets, err := json.Marshal(map[string]string{ServiceKeySecret: serviceKey})
+	require.NoError(t, err)
+
+	actual, err := ParseSecrets(string(secrets))
+	require.NoError(t, err)
+	require.Equal(t, "https://credstore", actual.URL)
+
+	actual, err = ParseSecrets(`{"other":"value"}`)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+
+	actual, err = ParseSecrets("")
+	require.NoError(t, err)
+	require.Nil(t, actual)
+}
+
+func TestParseSecrets_Invalid(t *testing.T) {
+	_, err := ParseSecrets(`{"service-key.json":"{\"url\":\"https://credstore\"}"}`)
+	require.ErrorContains(t, err, "invalid service-key.json in nodePublishSecretRef: service key certificate cannot be empty")
+
+	_, err = ParseSecrets(`[]`)
+	require.ErrorContains(t, err, "could not parse secrets field")
+}
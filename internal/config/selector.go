@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// defaultFileNameTemplate names the files of a selector after the matched credentials.
+const defaultFileNameTemplate = "{{ .Name }}"
+
+// SelectorData is available in the file name templates of selectors.
+type SelectorData struct {
+	Namespace string
+	Type      string
+	Name      string
+	Field     string
+}
+
+// IsSelector reports whether the credential selects credentials by name pattern.
+func (c Credential) IsSelector() bool {
+	return len(c.NamePattern) != 0
+}
+
+// ExpandSelector returns one credential for every name which matches the name pattern
+// of the selector. The file names are rendered from the selector's file name template.
+func ExpandSelector(selector Credential, names []string) ([]Credential, error) {
+	tmpl, err := parseFileNameTemplate(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	for _, name := range names {
+		if matched, _ := path.Match(selector.NamePattern, name); !matched {
+			continue
+		}
+
+		cred := selector
+		cred.Name = name
+		cred.NamePattern = ""
+
+		var fileName strings.Builder
+		data := SelectorData{Namespace: cred.Namespace, Type: cred.Type, Name: name, Field: cred.Field}
+		if err := tmpl.Execute(&fileName, data); err != nil {
+			return nil, fmt.Errorf("could not render file name for credential %s: %v", name, err)
+		}
+
+		cred.FileName = fileName.String()
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+func validateSelector(cred Credential) error {
+	if len(cred.Name) != 0 {
+		return fmt.Errorf("credential cannot set both name and namePattern")
+	}
+
+	if _, err := path.Match(cred.NamePattern, ""); err != nil {
+		return fmt.Errorf("invalid credential name pattern %s: %v", cred.NamePattern, err)
+	}
+
+	if _, err := parseFileNameTemplate(cred); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseFileNameTemplate(cred Credential) (*template.Template, error) {
+	text := cred.FileName
+	if len(text) == 0 {
+		text = defaultFileNameTemplate
+	}
+
+	tmpl, err := template.New("fileName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file name template for name pattern %s: %v", cred.NamePattern, err)
+	}
+
+	return tmpl, nil
+}
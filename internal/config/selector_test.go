@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	selectorCredential = `
+- namePattern: db-*
+  type: password
+  namespace: prod
+  fileName: "{{ .Namespace }}-{{ .Name }}.txt"
+- namePattern: "*"
+  type: key
+  namespace: prod
+`
+
+	selectorWithNameCredential = `
+- name: db-user
+  namePattern: db-*
+  type: password
+  namespace: prod
+`
+
+	invalidPatternCredential = `
+- namePattern: "db-["
+  type: password
+  namespace: prod
+`
+
+	invalidTemplateCredential = `
+- namePattern: db-*
+  type: password
+  namespace: prod
+  fileName: "{{ .Name "
+`
+)
+
+func TestParse_Selectors(t *testing.T) {
+	attributes, err := json.Marshal(map[string]string{"credentials": selectorCredential})
+	require.NoError(t, err)
+
+	actual, err := ParseParameters(string(attributes), "420")
+	require.NoError(t, err)
+	require.Equal(t, []Credential{
+		{Namespace: "prod", Type: "password", NamePattern: "db-*", FileName: "{{ .Namespace }}-{{ .Name }}.txt"},
+		{Namespace: "prod", Type: "key", NamePattern: "*"},
+	}, actual.Credentials)
+}
+
+func TestParse_SelectorErrors(t *testing.T) {
+	data := []struct {
+		name        string
+		credentials string
+		errorMsg    string
+	}{
+		{
+			name:        "name and name pattern",
+			credentials: selectorWithNameCredential,
+			errorMsg:    "credential cannot set both name and namePattern",
+		},
+		{
+			name:        "invalid name pattern",
+			credentials: invalidPatternCredential,
+			errorMsg:    "invalid credential name pattern db-[: syntax error in pattern",
+		},
+		{
+			name:        "invalid file name template",
+			credentials: invalidTemplateCredential,
+			errorMsg:    "invalid file name template for name pattern db-*",
+		},
+	}
+
+	for _, d := range data {
+		attributes, err := json.Marshal(map[string]string{"credentials": d.credentials})
+		require.NoError(t, err, d.name)
+
+		actual, err := ParseParameters(string(attributes), "420")
+		require.ErrorContains(t, err, d.errorMsg, d.name)
+		require.Equal(t, Parameters{}, actual, d.name)
+	}
+}
+
+func TestExpandSelector(t *testing.T) {
+	selector := Credential{Namespace: "prod", Type: "password", NamePattern: "db-*", FileName: "{{ .Name }}-{{ .Field }}", Field: "username"}
+
+	actual, err := ExpandSelector(selector, []string{"db-orders", "api-token", "db-users"})
+	require.NoError(t, err)
+	require.Equal(t, []Credential{
+		{Namespace: "prod", Type: "password", Name: "db-orders", FileName: "db-orders-username", Field: "username"},
+		{Namespace: "prod", Type: "password", Name: "db-users", FileName: "db-users-username", Field: "username"},
+	}, actual)
+	require.NoError(t, ValidateCredentials(actual))
+}
+
+func TestExpandSelector_DefaultFileName(t *testing.T) {
+	selector := Credential{Namespace: "prod", Type: "key", NamePattern: "*"}
+
+	actual, err := ExpandSelector(selector, []string{"aes", "hmac"})
+	require.NoError(t, err)
+	require.Equal(t, []Credential{
+		{Namespace: "prod", Type: "key", Name: "aes", FileName: "aes"},
+		{Namespace: "prod", Type: "key", Name: "hmac", FileName: "hmac"},
+	}, actual)
+}
+
+func TestExpandSelector_FileNameCollision(t *testing.T) {
+	selector := Credential{Namespace: "prod", Type: "password", NamePattern: "*", FileName: "{{ .Namespace }}.txt"}
+
+	actual, err := ExpandSelector(selector, []string{"first", "second"})
+	require.NoError(t, err)
+	require.EqualError(t, ValidateCredentials(actual), "file name must be unique, prod.txt is duplicated")
+}
+
+func TestExpandSelector_InvalidTemplateField(t *testing.T) {
+	selector := Credential{Namespace: "prod", Type: "password", NamePattern: "*", FileName: "{{ .Unknown }}"}
+
+	actual, err := ExpandSelector(selector, []string{"first"})
+	require.ErrorContains(t, err, "could not render file name for credential first")
+	require.Nil(t, actual)
+}
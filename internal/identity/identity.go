@@ -0,0 +1,266 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// ErrInvalidToken is returned for missing service account tokens and for tokens which
+// fail the verification.
+var ErrInvalidToken = errors.New("invalid service account token")
+
+// minRefreshInterval bounds how often tokens signed by unknown keys can make the
+// verifier fetch the key set again.
+const minRefreshInterval = 10 * time.Second
+
+// fetchTimeout bounds fetching the key set, which is detached from the waiting callers.
+const fetchTimeout = 30 * time.Second
+
+type Options struct {
+	// Issuer is the expected iss claim, e.g. the service account issuer of the cluster.
+	Issuer string
+	// Audience is the expected aud claim. It also selects the token among the tokens the
+	// driver requested for the pod.
+	Audience string
+	// JWKSURL is the location of the issuer's public keys. If empty, it is discovered
+	// through the OpenID configuration of the issuer.
+	JWKSURL string
+	// RefreshInterval is the interval in which the key set is fetched again.
+	RefreshInterval time.Duration
+	HTTP            *http.Client
+}
+
+// Identity holds the verified claims of a Kubernetes service account token.
+type Identity struct {
+	Subject        string
+	Namespace      string
+	ServiceAccount string
+	PodName        string
+}
+
+// Verifier verifies projected service account tokens against the keys of their issuer.
+type Verifier struct {
+	opts Options
+	now  func() time.Time
+
+	mu        sync.Mutex
+	keys      jwk.Set
+	fetchedAt time.Time
+	// refresh is the key set fetch in progress, nil if there is none.
+	refresh *keyRefresh
+}
+
+type keyRefresh struct {
+	done chan struct{}
+	keys jwk.Set
+	err  error
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	if len(opts.Issuer) == 0 {
+		return nil, fmt.Errorf("identity issuer cannot be empty")
+	}
+
+	if opts.HTTP == nil {
+		opts.HTTP = http.DefaultClient
+	}
+
+	return &Verifier{opts: opts, now: time.Now}, nil
+}
+
+// Audience returns the audience of the tokens the verifier accepts.
+func (v *Verifier) Audience() string {
+	return v.opts.Audience
+}
+
+// Verify validates the signature, issuer, audience and lifetime of the token and
+// returns the identity of the pod it was issued for.
+func (v *Verifier) Verify(ctx context.Context, token string) (Identity, error) {
+	if len(token) == 0 {
+		return Identity{}, fmt.Errorf("%w: no token for audience %q, check the tokenRequests of the CSIDriver", ErrInvalidToken, v.opts.Audience)
+	}
+
+	keys, err := v.keySet(ctx, false)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	parsed, err := v.parse(token, keys)
+	if err != nil {
+		// the issuer may have rotated its signing key since the key set was fetched
+		if keys, refreshErr := v.keySet(ctx, true); refreshErr == nil {
+			parsed, err = v.parse(token, keys)
+		}
+	}
+
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return identityOf(parsed)
+}
+
+func (v *Verifier) parse(token string, keys jwk.Set) (jwt.Token, error) {
+	return jwt.Parse([]byte(token),
+		jwt.WithKeySet(keys, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(v.opts.Issuer),
+		jwt.WithAudience(v.opts.Audience),
+		jwt.WithAcceptableSkew(30*time.Second),
+		jwt.WithClock(jwt.ClockFunc(v.now)),
+	)
+}
+
+// keySet returns the cached key set. It is fetched again once RefreshInterval passed or,
+// if refresh is set, at most every minRefreshInterval. Concurrent callers share a single
+// fetch and wait for it only as long as their own context allows, while callers which
+// can use the cached key set are not held up by a fetch in progress.
+func (v *Verifier) keySet(ctx context.Context, refresh bool) (jwk.Set, error) {
+	v.mu.Lock()
+	if v.keys != nil {
+		age := v.now().Sub(v.fetchedAt)
+		expired := v.opts.RefreshInterval > 0 && age >= v.opts.RefreshInterval
+		if !expired && !(refresh && age >= minRefreshInterval) {
+			keys := v.keys
+			v.mu.Unlock()
+			return keys, nil
+		}
+	}
+
+	r := v.refresh
+	if r == nil {
+		r = &keyRefresh{done: make(chan struct{})}
+		v.refresh = r
+		go v.runRefresh(r)
+	}
+	v.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.keys, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("could not fetch jwks: %w", ctx.Err())
+	}
+}
+
+// runRefresh fetches the key set detached from the caller which started the refresh. A
+// failed fetch keeps the previous key set in use.
+func (v *Verifier) runRefresh(r *keyRefresh) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	keys, err := v.fetchKeySet(ctx)
+
+	v.mu.Lock()
+	switch {
+	case err == nil:
+		v.keys = keys
+		v.fetchedAt = v.now()
+		r.keys = keys
+	case v.keys != nil:
+		r.keys = v.keys
+	default:
+		r.err = err
+	}
+	v.refresh = nil
+	v.mu.Unlock()
+
+	close(r.done)
+}
+
+func (v *Verifier) fetchKeySet(ctx context.Context) (jwk.Set, error) {
+	jwksURL := v.opts.JWKSURL
+	if len(jwksURL) == 0 {
+		var err error
+		jwksURL, err = v.discoverJWKSURL(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := jwk.Fetch(ctx, jwksURL, jwk.WithHTTPClient(v.opts.HTTP))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch jwks from %s: %v", jwksURL, err)
+	}
+
+	return keys, nil
+}
+
+func (v *Verifier) discoverJWKSURL(ctx context.Context) (string, error) {
+	configURL := strings.TrimSuffix(v.opts.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not build http request: %v", err)
+	}
+
+	resp, err := v.opts.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch openid configuration: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch openid configuration: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not fetch openid configuration: unexpected status: got %v", resp.Status)
+	}
+
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &discovery); err != nil || len(discovery.JWKSURI) == 0 {
+		return "", fmt.Errorf("openid configuration of %s contains no jwks_uri", v.opts.Issuer)
+	}
+
+	return discovery.JWKSURI, nil
+}
+
+// identityOf extracts the Kubernetes claims of a service account token.
+func identityOf(token jwt.Token) (Identity, error) {
+	var claims struct {
+		Namespace      string `json:"namespace"`
+		ServiceAccount struct {
+			Name string `json:"name"`
+		} `json:"serviceaccount"`
+		Pod struct {
+			Name string `json:"name"`
+		} `json:"pod"`
+	}
+
+	raw, ok := token.Get("kubernetes.io")
+	if ok {
+		encoded, err := json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(encoded, &claims)
+		}
+
+		if err != nil {
+			return Identity{}, fmt.Errorf("%w: invalid kubernetes.io claim: %v", ErrInvalidToken, err)
+		}
+	}
+
+	if len(claims.Namespace) == 0 || len(claims.ServiceAccount.Name) == 0 {
+		return Identity{}, fmt.Errorf("%w: token of %s is not a service account token", ErrInvalidToken, token.Subject())
+	}
+
+	return Identity{
+		Subject:        token.Subject(),
+		Namespace:      claims.Namespace,
+		ServiceAccount: claims.ServiceAccount.Name,
+		PodName:        claims.Pod.Name,
+	}, nil
+}
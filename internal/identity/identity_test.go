@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/identity/identitytest"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+var kubernetesClaims = map[string]any{
+	"kubernetes.io": map[string]any{
+		"namespace":      "team-a",
+		"serviceaccount": map[string]any{"name": "backend", "uid": "1"},
+		"pod":            map[string]any{"name": "backend-0", "uid": "2"},
+	},
+}
+
+func TestVerify(t *testing.T) {
+	iss := identitytest.NewIssuer(t)
+	v, err := NewVerifier(Options{Issuer: iss.URL, Audience: "credstore"})
+	require.NoError(t, err)
+
+	actual, err := v.Verify(context.Background(), iss.Token(t, "credstore", kubernetesClaims))
+	require.NoError(t, err)
+	require.Equal(t, Identity{
+		Subject:        "system:serviceaccount:team-a:backend",
+		Namespace:      "team-a",
+		ServiceAccount: "backend",
+		PodName:        "backend-0",
+	}, actual)
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	iss := identitytest.NewIssuer(t)
+	other := identitytest.NewIssuer(t)
+
+	v, err := NewVerifier(Options{Issuer: iss.URL, Audience: "credstore", JWKSURL: iss.URL + identitytest.JWKSPath})
+	require.NoError(t, err)
+
+	expired := func() string {
+		token, err := jwt.NewBuilder().Issuer(iss.URL).Audience([]string{"credstore"}).Expiration(time.Now().Add(-time.Hour)).
+			Claim("kubernetes.io", kubernetesClaims["kubernetes.io"]).Build()
+		require.NoError(t, err)
+		return iss.Sign(t, token)
+	}()
+
+	tampered := []byte(iss.Token(t, "credstore", kubernetesClaims))
+	tampered[len(tampered)-5] ^= 1
+
+	data := []struct {
+		name     string
+		token    string
+		errorMsg string
+	}{
+		{name: "missing", token: "", errorMsg: "no token for audience"},
+		{name: "wrong audience", token: iss.Token(t, "api", kubernetesClaims), errorMsg: `"aud" not satisfied`},
+		{name: "foreign issuer", token: other.Token(t, "credstore", kubernetesClaims), errorMsg: "invalid service account token"},
+		{name: "expired", token: expired, errorMsg: `"exp" not satisfied`},
+		{name: "tampered", token: string(tampered), errorMsg: "invalid service account token"},
+		{name: "not a service account", token: iss.Token(t, "credstore", nil), errorMsg: "is not a service account token"},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), d.token)
+			require.ErrorIs(t, err, ErrInvalidToken)
+			require.ErrorContains(t, err, d.errorMsg)
+		})
+	}
+}
+
+func TestVerify_KeyRotation(t *testing.T) {
+	iss := identitytest.NewIssuer(t)
+	v, err := NewVerifier(Options{Issuer: iss.URL, Audience: "credstore"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	_, err = v.Verify(context.Background(), iss.Token(t, "credstore", kubernetesClaims))
+	require.NoError(t, err)
+
+	// tokens signed with a new key fail until the key set may be fetched again
+	iss.Rotate(t)
+	rotated := iss.Token(t, "credstore", kubernetesClaims)
+	_, err = v.Verify(context.Background(), rotated)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Equal(t, 1, iss.Fetches())
+
+	now = now.Add(minRefreshInterval)
+	_, err = v.Verify(context.Background(), rotated)
+	require.NoError(t, err)
+	require.Equal(t, 2, iss.Fetches())
+}
+
+func TestVerify_SlowIssuer(t *testing.T) {
+	iss := identitytest.NewIssuer(t)
+	v, err := NewVerifier(Options{Issuer: iss.URL, Audience: "credstore"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	token := iss.Token(t, "credstore", kubernetesClaims)
+	_, err = v.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// a token signed with a new key makes the verifier fetch the key set from the stalled issuer
+	release := iss.Hold()
+	iss.Rotate(t)
+	now = now.Add(minRefreshInterval)
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), iss.Token(t, "credstore", kubernetesClaims))
+		refreshed <- err
+	}()
+	require.Eventually(t, func() bool { return iss.Fetches() == 2 }, time.Second, time.Millisecond)
+
+	// tokens of known keys are verified without waiting for the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+
+	// callers which need the fetch give up on their own deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = v.keySet(ctx, true)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	require.NoError(t, <-refreshed)
+	require.Equal(t, 2, iss.Fetches())
+}
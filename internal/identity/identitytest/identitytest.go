@@ -0,0 +1,161 @@
+// Package identitytest provides a stand-in for the service account token issuer of a
+// cluster, for tests which verify pod identities.
+package identitytest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// JWKSPath is the path of the key set, which the OpenID configuration of the issuer points to.
+const JWKSPath = "/openid/v1/jwks"
+
+// Issuer serves the OpenID discovery and JWKS endpoints of a cluster and signs tokens
+// with the latest of its keys.
+type Issuer struct {
+	// URL is the issuer URL, i.e. the expected iss claim.
+	URL string
+
+	mu      sync.Mutex
+	keys    []jwk.Key
+	fetches int
+	held    chan struct{}
+}
+
+func NewIssuer(t testing.TB) *Issuer {
+	t.Helper()
+
+	iss := &Issuer{}
+	iss.Rotate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + JWKSPath})
+	})
+	mux.HandleFunc(JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		iss.fetches++
+		held := iss.held
+		set := jwk.NewSet()
+		for _, key := range iss.keys {
+			public, err := key.PublicKey()
+			require.NoError(t, err)
+			set.AddKey(public)
+		}
+		iss.mu.Unlock()
+
+		if held != nil {
+			<-held
+		}
+
+		json.NewEncoder(w).Encode(set)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	iss.URL = srv.URL
+
+	return iss
+}
+
+// Rotate adds a new signing key to the key set.
+func (iss *Issuer) Rotate(t testing.TB) {
+	t.Helper()
+
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+	require.NoError(t, jwk.AssignKeyID(key))
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	iss.keys = append(iss.keys, key)
+}
+
+// Fetches returns the number of key set requests served so far.
+func (iss *Issuer) Fetches() int {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	return iss.fetches
+}
+
+// Hold makes key set requests wait until the returned function is called, e.g. to
+// simulate a slow issuer.
+func (iss *Issuer) Hold() func() {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	held := make(chan struct{})
+	iss.held = held
+
+	return func() {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+
+		close(held)
+		iss.held = nil
+	}
+}
+
+// Sign signs the token with the latest key of the issuer.
+func (iss *Issuer) Sign(t testing.TB, token jwt.Token) string {
+	t.Helper()
+
+	iss.mu.Lock()
+	key := iss.keys[len(iss.keys)-1]
+	iss.mu.Unlock()
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	require.NoError(t, err)
+
+	return string(signed)
+}
+
+// Token issues a token for the audience which is valid for an hour.
+func (iss *Issuer) Token(t testing.TB, audience string, claims map[string]any) string {
+	t.Helper()
+
+	builder := jwt.NewBuilder().
+		Issuer(iss.URL).
+		Audience([]string{audience}).
+		Subject("system:serviceaccount:team-a:backend").
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(time.Hour))
+	for name, value := range claims {
+		builder = builder.Claim(name, value)
+	}
+
+	token, err := builder.Build()
+	require.NoError(t, err)
+
+	return iss.Sign(t, token)
+}
+
+// ServiceAccountToken issues a token for the audience with the Kubernetes claims of
+// the service account.
+func (iss *Issuer) ServiceAccountToken(t testing.TB, audience, namespace, serviceAccount string) string {
+	t.Helper()
+
+	return iss.Token(t, audience, map[string]any{
+		"sub": "system:serviceaccount:" + namespace + ":" + serviceAccount,
+		"kubernetes.io": map[string]any{
+			"namespace":      namespace,
+			"serviceaccount": map[string]any{"name": serviceAccount},
+		},
+	})
+}
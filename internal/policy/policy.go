@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrDenied is returned for credentials which no rule allows the pod to read.
+var ErrDenied = errors.New("denied by policy")
+
+// Policy restricts which pods may read which Credential Store credentials. A request is
+// allowed if at least one rule matches it. A nil Policy allows every request.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule allows pods matching the Kubernetes side of the rule to read credentials matching
+// its Credential Store side. All values are path.Match patterns, e.g. team-a-*.
+type Rule struct {
+	// PodNamespaces are the Kubernetes namespaces of the pods the rule applies to.
+	PodNamespaces []string `yaml:"podNamespaces"`
+	// ServiceAccounts restricts the rule to pods running as one of the service accounts, empty means any.
+	ServiceAccounts []string `yaml:"serviceAccounts,omitempty"`
+	// ServiceKeys restricts the rule to the named service keys of the provider, empty means any.
+	ServiceKeys []string `yaml:"serviceKeys,omitempty"`
+	// Namespaces are the Credential Store namespaces which may be read.
+	Namespaces []string `yaml:"namespaces"`
+	// Names restricts the credentials which may be read, empty means any.
+	Names []string `yaml:"names,omitempty"`
+}
+
+// Names of the service keys in requests which are not made with a named service key of
+// the provider, so that rules can be restricted to them.
+const (
+	// DefaultServiceKey names the default service key of the provider.
+	DefaultServiceKey = "default"
+	// PodServiceKey names any service key supplied by the pod through its nodePublishSecretRef.
+	PodServiceKey = "pod"
+)
+
+// Request describes a pod reading a credential or, with an empty Name, listing the
+// credentials of a namespace.
+type Request struct {
+	PodNamespace   string
+	PodName        string
+	ServiceAccount string
+	ServiceKey     string
+	Namespace      string
+	Type           string
+	Name           string
+}
+
+func Parse(yamlBytes []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := yaml.Unmarshal(yamlBytes, policy); err != nil {
+		return nil, fmt.Errorf("could not parse policy: %v", err)
+	}
+
+	for i, rule := range policy.Rules {
+		if len(rule.PodNamespaces) == 0 {
+			return nil, fmt.Errorf("policy rule %d: podNamespaces cannot be empty", i)
+		}
+
+		if len(rule.Namespaces) == 0 {
+			return nil, fmt.Errorf("policy rule %d: namespaces cannot be empty", i)
+		}
+
+		for _, patterns := range [][]string{rule.PodNamespaces, rule.ServiceAccounts, rule.ServiceKeys, rule.Namespaces, rule.Names} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("policy rule %d: invalid pattern %s: %v", i, pattern, err)
+				}
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// Allow returns an error wrapping ErrDenied unless a rule allows the request. Requests
+// with an empty Name only need to be allowed to access the namespace.
+func (p *Policy) Allow(req Request) error {
+	if p == nil {
+		return nil
+	}
+
+	if len(req.PodNamespace) != 0 {
+		for _, rule := range p.Rules {
+			if rule.allows(req) {
+				return nil
+			}
+		}
+	}
+
+	if len(req.Name) == 0 {
+		return fmt.Errorf("%w: pod %s/%s may not list %ss in %s", ErrDenied, req.PodNamespace, req.PodName, req.Type, req.Namespace)
+	}
+
+	return fmt.Errorf("%w: pod %s/%s may not read %s %s/%s", ErrDenied, req.PodNamespace, req.PodName, req.Type, req.Namespace, req.Name)
+}
+
+func (r Rule) allows(req Request) bool {
+	if !matchAny(r.PodNamespaces, req.PodNamespace, false) ||
+		!matchAny(r.ServiceAccounts, req.ServiceAccount, true) ||
+		!matchAny(r.ServiceKeys, req.ServiceKey, true) ||
+		!matchAny(r.Namespaces, req.Namespace, false) {
+		return false
+	}
+
+	return len(req.Name) == 0 || matchAny(r.Names, req.Name, true)
+}
+
+func matchAny(patterns []string, value string, emptyMatches bool) bool {
+	if len(patterns) == 0 {
+		return emptyMatches
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
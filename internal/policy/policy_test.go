@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const policyYaml = `
+rules:
+- podNamespaces: [team-a]
+  namespaces: [team-a, shared]
+- podNamespaces: [team-b-*]
+  serviceAccounts: [backend]
+  serviceKeys: [team-b]
+  namespaces: [team-b]
+  names: [db-*]
+`
+
+func TestAllow(t *testing.T) {
+	policy, err := Parse([]byte(policyYaml))
+	require.NoError(t, err)
+
+	data := []struct {
+		name    string
+		req     Request
+		allowed bool
+	}{
+		{
+			name:    "own namespace",
+			req:     Request{PodNamespace: "team-a", ServiceAccount: "default", Namespace: "team-a", Type: "password", Name: "db"},
+			allowed: true,
+		},
+		{
+			name:    "shared namespace",
+			req:     Request{PodNamespace: "team-a", Namespace: "shared", Type: "key", Name: "api"},
+			allowed: true,
+		},
+		{
+			name: "foreign namespace",
+			req:  Request{PodNamespace: "team-a", Namespace: "team-b", Type: "password", Name: "db-user"},
+		},
+		{
+			name:    "matching service account, key and name",
+			req:     Request{PodNamespace: "team-b-prod", ServiceAccount: "backend", ServiceKey: "team-b", Namespace: "team-b", Type: "password", Name: "db-user"},
+			allowed: true,
+		},
+		{
+			name: "other service account",
+			req:  Request{PodNamespace: "team-b-prod", ServiceAccount: "frontend", ServiceKey: "team-b", Namespace: "team-b", Type: "password", Name: "db-user"},
+		},
+		{
+			name: "default service key",
+			req:  Request{PodNamespace: "team-b-prod", ServiceAccount: "backend", Namespace: "team-b", Type: "password", Name: "db-user"},
+		},
+		{
+			name: "other name",
+			req:  Request{PodNamespace: "team-b-prod", ServiceAccount: "backend", ServiceKey: "team-b", Namespace: "team-b", Type: "password", Name: "admin"},
+		},
+		{
+			name:    "list namespace",
+			req:     Request{PodNamespace: "team-b-prod", ServiceAccount: "backend", ServiceKey: "team-b", Namespace: "team-b", Type: "password"},
+			allowed: true,
+		},
+		{
+			name: "unknown pod",
+			req:  Request{Namespace: "team-a", Type: "password", Name: "db"},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			err := policy.Allow(d.req)
+			if d.allowed {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrDenied)
+		})
+	}
+}
+
+func TestAllow_NilPolicy(t *testing.T) {
+	var policy *Policy
+	require.NoError(t, policy.Allow(Request{Namespace: "team-a", Type: "password", Name: "db"}))
+}
+
+func TestAllow_ErrorMessage(t *testing.T) {
+	policy, err := Parse([]byte(policyYaml))
+	require.NoError(t, err)
+
+	err = policy.Allow(Request{PodNamespace: "team-a", PodName: "app", Namespace: "team-b", Type: "password", Name: "db"})
+	require.EqualError(t, err, "denied by policy: pod team-a/app may not read password team-b/db")
+}
+
+func TestParse_Invalid(t *testing.T) {
+	data := []struct {
+		name     string
+		yaml     string
+		errorMsg string
+	}{
+		{name: "malformed", yaml: "rules: {", errorMsg: "could not parse policy"},
+		{name: "no pod namespaces", yaml: "rules:\n- namespaces: [a]", errorMsg: "policy rule 0: podNamespaces cannot be empty"},
+		{name: "no namespaces", yaml: "rules:\n- podNamespaces: [a]", errorMsg: "policy rule 0: namespaces cannot be empty"},
+		{name: "invalid pattern", yaml: "rules:\n- podNamespaces: [a]\n  namespaces: ['[']", errorMsg: "policy rule 0: invalid pattern ["},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			_, err := Parse([]byte(d.yaml))
+			require.ErrorContains(t, err, d.errorMsg)
+		})
+	}
+}
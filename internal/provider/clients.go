@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+)
+
+// ClientFactory builds a Credential Store client for a service key which a pod supplied
+// through the nodePublishSecretRef of its volume.
+type ClientFactory func(serviceKey config.ServiceKey) (*client.Client, error)
+
+// clientCache keeps the clients built for pod service keys, so that mount requests of
+// pods sharing a key reuse its connections. Clients are keyed by the service key
+// fingerprint and the least recently used ones are evicted first.
+type clientCache struct {
+	factory ClientFactory
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type clientEntry struct {
+	fingerprint string
+	client      *client.Client
+}
+
+func newClientCache(factory ClientFactory, maxSize int) *clientCache {
+	return &clientCache{
+		factory: factory,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *clientCache) get(serviceKey config.ServiceKey) (*client.Client, error) {
+	fingerprint := serviceKey.Fingerprint()
+
+	c.mu.Lock()
+	if elem, ok := c.entries[fingerprint]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*clientEntry).client, nil
+	}
+	c.mu.Unlock()
+
+	built, err := c.factory(serviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another mount request may have built a client for the same key in the meantime
+	if elem, ok := c.entries[fingerprint]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*clientEntry).client, nil
+	}
+
+	c.entries[fingerprint] = c.lru.PushFront(&clientEntry{fingerprint: fingerprint, client: built})
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		evicted := oldest.Value.(*clientEntry)
+		delete(c.entries, evicted.fingerprint)
+		evicted.client.CloseIdleConnections()
+	}
+
+	return built, nil
+}
+
+func (c *clientCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCache(t *testing.T) {
+	built := 0
+	c := newClientCache(func(serviceKey config.ServiceKey) (*client.Client, error) {
+		built++
+		return &client.Client{BaseURL: serviceKey.URL}, nil
+	}, 2)
+
+	keyA := config.ServiceKey{URL: "https://a"}
+	keyB := config.ServiceKey{URL: "https://b"}
+	keyC := config.ServiceKey{URL: "https://c"}
+
+	first, err := c.get(keyA)
+	require.NoError(t, err)
+	second, err := c.get(keyA)
+	require.NoError(t, err)
+	require.Same(t, first, second)
+	require.Equal(t, 1, built)
+
+	_, err = c.get(keyB)
+	require.NoError(t, err)
+	_, err = c.get(keyA)
+	require.NoError(t, err)
+	_, err = c.get(keyC)
+	require.NoError(t, err)
+	require.Equal(t, 2, c.len())
+
+	// keyB was the least recently used client and got evicted
+	_, err = c.get(keyB)
+	require.NoError(t, err)
+	require.Equal(t, 4, built)
+}
+
+func TestClientCache_KeySecrets(t *testing.T) {
+	c := newClientCache(func(serviceKey config.ServiceKey) (*client.Client, error) {
+		return &client.Client{BaseURL: serviceKey.URL}, nil
+	}, 0)
+
+	genuine, err := c.get(config.ServiceKey{URL: "https://a", Certificate: "cert", Key: "key"})
+	require.NoError(t, err)
+	forged, err := c.get(config.ServiceKey{URL: "https://a", Certificate: "cert", Key: "junk"})
+	require.NoError(t, err)
+	require.NotSame(t, genuine, forged)
+}
+
+func TestClientCache_FactoryError(t *testing.T) {
+	c := newClientCache(func(config.ServiceKey) (*client.Client, error) {
+		return nil, errors.New("could not parse x509 key pair")
+	}, 0)
+
+	_, err := c.get(config.ServiceKey{URL: "https://a"})
+	require.EqualError(t, err, "could not parse x509 key pair")
+	require.Equal(t, 0, c.len())
+}
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/cache"
+)
+
+// flightGroup coalesces concurrent fetches of the same credential into a single
+// upstream request whose result is shared by every waiting caller.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[cache.Key]*flightCall
+}
+
+type flightCall struct {
+	ctx    *flightContext
+	done   chan struct{}
+	fields map[string]string
+	err    error
+}
+
+// do runs fetch once for all concurrent callers with the same key. The fetch is
+// detached from the caller which started it, so cancelling one caller only stops
+// its own wait and does not fail the fetch for the others. The fetch honours the
+// latest deadline among the waiting callers, so that a caller with a short timeout
+// does not fail the callers which joined with a longer one.
+func (g *flightGroup) do(ctx context.Context, key cache.Key, fetch cache.FetchFunc) (map[string]string, error) {
+	deadline, hasDeadline := ctx.Deadline()
+
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[cache.Key]*flightCall)
+	}
+
+	// a call whose deadline already passed is about to fail, so it is not joined
+	call, ok := g.calls[key]
+	if !ok || !call.ctx.extend(deadline, hasDeadline) {
+		call = &flightCall{ctx: newFlightContext(deadline, hasDeadline), done: make(chan struct{})}
+		g.calls[key] = call
+
+		go func() {
+			defer call.ctx.cancel()
+			call.fields, call.err = fetch(call.ctx)
+
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.fields, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flightContext is the detached context of a shared fetch. Unlike a context from
+// context.WithDeadline, its deadline can be moved back while the fetch is running.
+// A zero deadline means none.
+type flightContext struct {
+	context.Context
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func newFlightContext(deadline time.Time, hasDeadline bool) *flightContext {
+	c := &flightContext{Context: context.Background(), done: make(chan struct{})}
+	if hasDeadline {
+		c.deadline = deadline
+		c.timer = time.AfterFunc(time.Until(deadline), c.expire)
+	}
+
+	return c
+}
+
+// extend moves the deadline to the given one if it is later. A caller without a
+// deadline removes it. It returns false if the context already ended.
+func (c *flightContext) extend(deadline time.Time, hasDeadline bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return false
+	}
+
+	if c.deadline.IsZero() {
+		return true
+	}
+
+	if !hasDeadline {
+		c.deadline = time.Time{}
+		c.timer.Stop()
+		return true
+	}
+
+	if deadline.After(c.deadline) {
+		c.deadline = deadline
+		c.timer.Reset(time.Until(deadline))
+	}
+
+	return true
+}
+
+func (c *flightContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.deadline.IsZero() {
+		return
+	}
+
+	// the deadline may have been extended while the timer fired
+	if remaining := time.Until(c.deadline); remaining > 0 {
+		c.timer.Reset(remaining)
+		return
+	}
+
+	c.finish(context.DeadlineExceeded)
+}
+
+func (c *flightContext) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
+	c.finish(context.Canceled)
+}
+
+// finish must be called with c.mu held.
+func (c *flightContext) finish(err error) {
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+func (c *flightContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.deadline, !c.deadline.IsZero()
+}
+
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *flightContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
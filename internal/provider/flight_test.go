@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var flightKey = cache.Key{ServiceKey: "sk", Namespace: "dev", Type: "password", Name: "myPassword"}
+
+func TestFlightGroup_CoalescesConcurrentFetches(t *testing.T) {
+	g := &flightGroup{}
+	release := make(chan struct{})
+	var calls int32
+
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return map[string]string{"value": "secret"}, nil
+	}
+
+	results := make([]map[string]string, 20)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var err error
+			results[i], err = g.do(context.Background(), flightKey, fetch)
+			assert.NoError(t, err)
+		}(i)
+	}
+
+	// Give all callers the chance to join the in-flight fetch before releasing it
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.EqualValues(t, 1, calls)
+	for _, result := range results {
+		require.Equal(t, "secret", result["value"])
+	}
+}
+
+func TestFlightGroup_CancelledCallerDoesNotCancelFetch(t *testing.T) {
+	g := &flightGroup{}
+	release := make(chan struct{})
+	fetchErr := make(chan error, 1)
+
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		<-release
+		fetchErr <- ctx.Err()
+		return map[string]string{"value": "secret"}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.do(ctx, flightKey, fetch)
+		first <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	second := make(chan map[string]string, 1)
+	go func() {
+		fields, err := g.do(context.Background(), flightKey, fetch)
+		assert.NoError(t, err)
+		second <- fields
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-first, context.Canceled)
+
+	close(release)
+	require.NoError(t, <-fetchErr)
+	require.Equal(t, "secret", (<-second)["value"])
+}
+
+func TestFlightGroup_LatestDeadlineWins(t *testing.T) {
+	g := &flightGroup{}
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		select {
+		case <-time.After(150 * time.Millisecond):
+			return map[string]string{"value": "secret"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.do(short, flightKey, fetch)
+		first <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	long, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the fetch outlives the deadline of the caller which started it
+	fields, err := g.do(long, flightKey, fetch)
+	require.NoError(t, err)
+	require.Equal(t, "secret", fields["value"])
+	require.ErrorIs(t, <-first, context.DeadlineExceeded)
+}
+
+func TestFlightGroup_DeadlineExceeded(t *testing.T) {
+	g := &flightGroup{}
+	fetchErr := make(chan error, 1)
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		<-ctx.Done()
+		fetchErr <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := g.do(ctx, flightKey, fetch)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorIs(t, <-fetchErr, context.DeadlineExceeded)
+}
+
+func TestFlightGroup_ExpiredCallIsNotJoined(t *testing.T) {
+	g := &flightGroup{}
+	finish := make(chan struct{})
+
+	// the first fetch outlives its deadline, e.g. while it waits for a response
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.do(ctx, flightKey, func(ctx context.Context) (map[string]string, error) {
+		<-finish
+		return nil, ctx.Err()
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the caller may give up just before the fetch context expires
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls[flightKey].ctx.Err() != nil
+	}, time.Second, time.Millisecond)
+
+	actual, err := g.do(context.Background(), flightKey, func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"value": "secret"}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "secret", actual["value"])
+
+	// the first fetch finishing late does not drop the call of the second
+	close(finish)
+}
+
+func TestFlightGroup_SequentialFetchesAreNotShared(t *testing.T) {
+	g := &flightGroup{}
+	var calls int32
+
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		atomic.AddInt32(&calls, 1)
+		return map[string]string{"value": "secret"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := g.do(context.Background(), flightKey, fetch)
+		require.NoError(t, err)
+	}
+
+	require.EqualValues(t, 3, calls)
+}
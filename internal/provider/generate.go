@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+)
+
+// createCredential creates a missing credential with a generated value. Pods on other
+// nodes may race to create the same credential, the ones which lose the race mount the
+// value of the winner.
+func (p *Provider) createCredential(ctx context.Context, c *client.Client, ref credentialRef, gen config.Generate) (map[string]string, error) {
+	value, err := generateValue(ref.Type, gen)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate %s %s/%s: %v", ref.Type, ref.Namespace, ref.Name, err)
+	}
+
+	var fields map[string]string
+	switch ref.Type {
+	case "password":
+		var pass *client.PasswordCredential
+		if pass, err = c.CreatePassword(ctx, ref.Namespace, ref.Name, value); err == nil {
+			fields = pass.Fields()
+		}
+	case "key":
+		var key *client.KeyCredential
+		if key, err = c.CreateKey(ctx, ref.Namespace, ref.Name, gen.Format, value); err == nil {
+			fields = key.Fields()
+		}
+	default:
+		return nil, fmt.Errorf("invalid credential type %s", ref.Type)
+	}
+
+	if errors.Is(err, client.ErrConflict) {
+		p.logger.Infow("credential was created concurrently, mounting the existing value",
+			"namespace", ref.Namespace,
+			"type", ref.Type,
+			"name", ref.Name,
+		)
+		return getCredentialFields(ctx, c, ref)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.logger.Infow("created missing credential with generated value",
+		"namespace", ref.Namespace,
+		"type", ref.Type,
+		"name", ref.Name,
+	)
+
+	return fields, nil
+}
+
+// generateValue returns a random password, or a base64 encoded key. AES keys are raw
+// bytes, RSA and EC keys PKCS#8 encoded private keys.
+func generateValue(credType string, gen config.Generate) (string, error) {
+	switch credType {
+	case "password":
+		return generatePassword(gen.Length, config.Charsets[gen.Charset])
+	case "key":
+		key, err := generateKey(gen.Format, gen.KeySize)
+		if err != nil {
+			return "", err
+		}
+
+		return base64.StdEncoding.EncodeToString(key), nil
+	}
+
+	return "", fmt.Errorf("values of type %s cannot be generated", credType)
+}
+
+func generatePassword(length int, charset string) (string, error) {
+	if length <= 0 || len(charset) == 0 {
+		return "", fmt.Errorf("invalid password length %d or charset", length)
+	}
+
+	size := big.NewInt(int64(len(charset)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+
+		password[i] = charset[n.Int64()]
+	}
+
+	return string(password), nil
+}
+
+func generateKey(format string, keySize int) ([]byte, error) {
+	var privkey any
+	var err error
+	switch format {
+	case "AES":
+		if keySize != 128 && keySize != 192 && keySize != 256 {
+			return nil, fmt.Errorf("invalid AES key size %d", keySize)
+		}
+
+		key := make([]byte, keySize/8)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	case "RSA":
+		if keySize < 2048 {
+			return nil, fmt.Errorf("invalid RSA key size %d", keySize)
+		}
+
+		privkey, err = rsa.GenerateKey(rand.Reader, keySize)
+	case "EC":
+		curves := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		curve, ok := curves[keySize]
+		if !ok {
+			return nil, fmt.Errorf("invalid EC key size %d", keySize)
+		}
+
+		privkey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key format %s", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.MarshalPKCS8PrivateKey(privkey)
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kloyan/credstore-csi-provider/internal/cache"
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+func generateParams() config.Parameters {
+	return config.Parameters{
+		Permission: 420,
+		Credentials: []config.Credential{{
+			Namespace:         "dev",
+			Type:              "password",
+			Name:              "db",
+			FileName:          "db-password",
+			GenerateIfMissing: &config.Generate{Length: 24, Charset: "hex"},
+		}},
+	}
+}
+
+func TestHandleMountRequest_GenerateIfMissing(t *testing.T) {
+	store, c := newPasswordStore(t)
+
+	// creating credentials has to be allowed by the provider
+	_, err := NewProvider(c, Options{}).HandleMountRequest(context.Background(), generateParams())
+	require.ErrorIs(t, err, client.ErrNotFound)
+	require.ErrorContains(t, err, "generateIfMissing is not allowed by the provider")
+	require.Equal(t, 0, store.creates)
+
+	p := NewProvider(c, Options{AllowGenerate: true})
+	resp, err := p.HandleMountRequest(context.Background(), generateParams())
+	require.NoError(t, err)
+
+	generated := string(resp.Files[0].Contents)
+	require.Len(t, generated, 24)
+	require.Empty(t, strings.Trim(generated, config.Charsets["hex"]))
+	require.Equal(t, generated, store.passwords["db"].Value)
+
+	// later mounts read the created value
+	resp, err = p.HandleMountRequest(context.Background(), generateParams())
+	require.NoError(t, err)
+	require.Equal(t, generated, string(resp.Files[0].Contents))
+	require.Equal(t, 1, store.creates)
+
+	// without generateIfMissing a missing credential still fails the mount
+	params := generateParams()
+	params.Credentials[0].Name = "other"
+	params.Credentials[0].GenerateIfMissing = nil
+	_, err = p.HandleMountRequest(context.Background(), params)
+	require.ErrorIs(t, err, client.ErrNotFound)
+}
+
+func TestHandleMountRequest_GenerateIfMissingRace(t *testing.T) {
+	store, c := newPasswordStore(t)
+	store.racingValue = "created-by-another-pod"
+
+	resp, err := NewProvider(c, Options{AllowGenerate: true}).HandleMountRequest(context.Background(), generateParams())
+	require.NoError(t, err)
+	require.Equal(t, "created-by-another-pod", string(resp.Files[0].Contents))
+	require.Equal(t, 0, store.creates)
+
+	// pods on several nodes mount the same value, whichever created it
+	store, c = newPasswordStore(t)
+	contents := make([]string, 5)
+	wg := sync.WaitGroup{}
+	for i := range contents {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := NewProvider(c, Options{AllowGenerate: true}).HandleMountRequest(context.Background(), generateParams())
+			if assert.NoError(t, err) {
+				contents[i] = string(resp.Files[0].Contents)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	require.Equal(t, 1, store.creates)
+	for _, content := range contents {
+		require.Equal(t, store.passwords["db"].Value, content)
+	}
+}
+
+func TestHandleMountRequest_GenerateIfMissingSharedLookup(t *testing.T) {
+	store, c := newPasswordStore(t)
+	store.held = make(chan struct{})
+	p := NewProvider(c, Options{AllowGenerate: true})
+
+	key := cache.Key{ServiceKey: c.Fingerprint, Namespace: "dev", Type: "password", Name: "db"}
+	lookup := func() *flightCall {
+		p.flight.mu.Lock()
+		defer p.flight.mu.Unlock()
+
+		return p.flight.calls[key]
+	}
+
+	// a mount without generateIfMissing starts the lookup
+	plain := generateParams()
+	plain.Credentials[0].GenerateIfMissing = nil
+	plainErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+
+		_, err := p.HandleMountRequest(ctx, plain)
+		plainErr <- err
+	}()
+	require.Eventually(t, func() bool { return lookup() != nil }, time.Second, time.Millisecond)
+
+	// a mount with generateIfMissing joins it, which lifts the deadline of the lookup
+	generated := make(chan *pb.MountResponse, 1)
+	go func() {
+		resp, err := p.HandleMountRequest(context.Background(), generateParams())
+		assert.NoError(t, err)
+		generated <- resp
+	}()
+	require.Eventually(t, func() bool {
+		_, hasDeadline := lookup().ctx.Deadline()
+		return !hasDeadline
+	}, time.Second, time.Millisecond)
+	close(store.held)
+
+	// only the mount with generateIfMissing creates the credential
+	require.ErrorIs(t, <-plainErr, client.ErrNotFound)
+	resp := <-generated
+	require.NotNil(t, resp)
+	require.Equal(t, store.passwords["db"].Value, string(resp.Files[0].Contents))
+	require.Equal(t, 1, store.gets)
+	require.Equal(t, 1, store.creates)
+}
+
+func TestGenerateValue_Keys(t *testing.T) {
+	data := []struct {
+		format  string
+		keySize int
+		check   func(t *testing.T, der []byte)
+	}{
+		{format: "AES", keySize: 192, check: func(t *testing.T, der []byte) { require.Len(t, der, 24) }},
+		{format: "RSA", keySize: 2048, check: func(t *testing.T, der []byte) {
+			key, err := x509.ParsePKCS8PrivateKey(der)
+			require.NoError(t, err)
+			require.Equal(t, 2048, key.(*rsa.PrivateKey).N.BitLen())
+		}},
+		{format: "EC", keySize: 384, check: func(t *testing.T, der []byte) {
+			key, err := x509.ParsePKCS8PrivateKey(der)
+			require.NoError(t, err)
+			require.Equal(t, 384, key.(*ecdsa.PrivateKey).Curve.Params().BitSize)
+		}},
+	}
+
+	for _, d := range data {
+		t.Run(d.format, func(t *testing.T) {
+			value, err := generateValue("key", config.Generate{Format: d.format, KeySize: d.keySize})
+			require.NoError(t, err)
+
+			der, err := base64.StdEncoding.DecodeString(value)
+			require.NoError(t, err)
+			d.check(t, der)
+		})
+	}
+
+	_, err := generateValue("key", config.Generate{Format: "RSA", KeySize: 1024})
+	require.EqualError(t, err, "invalid RSA key size 1024")
+
+	_, err = generateValue("certificate", config.Generate{})
+	require.EqualError(t, err, "values of type certificate cannot be generated")
+}
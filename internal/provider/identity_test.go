@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/kloyan/credstore-csi-provider/internal/identity"
+	"github.com/kloyan/credstore-csi-provider/internal/identity/identitytest"
+	"github.com/kloyan/credstore-csi-provider/internal/policy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMountRequest_Identity(t *testing.T) {
+	iss := identitytest.NewIssuer(t)
+	verifier, err := identity.NewVerifier(identity.Options{Issuer: iss.URL, Audience: "credstore", JWKSURL: iss.URL + identitytest.JWKSPath})
+	require.NoError(t, err)
+	issue := func(namespace, serviceAccount string) string {
+		return iss.ServiceAccountToken(t, "credstore", namespace, serviceAccount)
+	}
+
+	pol, err := policy.Parse([]byte("rules:\n- podNamespaces: [team-a]\n  serviceAccounts: [backend]\n  namespaces: [team-a]\n"))
+	require.NoError(t, err)
+
+	p := NewProvider(newFailingClient(t, map[string]int{"db": http.StatusNotFound}), Options{Policy: pol, Identity: verifier})
+
+	data := []struct {
+		name   string
+		pod    config.Pod
+		tokens map[string]string
+		err    error
+	}{
+		{
+			name:   "verified identity",
+			pod:    config.Pod{Namespace: "team-a", Name: "app", ServiceAccount: "backend"},
+			tokens: map[string]string{"credstore": issue("team-a", "backend")},
+			err:    client.ErrNotFound,
+		},
+		{
+			name: "missing token",
+			pod:  config.Pod{Namespace: "team-a", Name: "app", ServiceAccount: "backend"},
+			err:  identity.ErrInvalidToken,
+		},
+		{
+			name:   "token of another pod",
+			pod:    config.Pod{Namespace: "team-a", Name: "app", ServiceAccount: "backend"},
+			tokens: map[string]string{"credstore": issue("team-b", "backend")},
+			err:    identity.ErrInvalidToken,
+		},
+		{
+			name:   "verified identity denied by policy",
+			tokens: map[string]string{"credstore": issue("team-a", "frontend")},
+			err:    policy.ErrDenied,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			params := config.Parameters{
+				Pod:                  d.pod,
+				ServiceAccountTokens: d.tokens,
+				Credentials:          []config.Credential{{Namespace: "team-a", Type: "password", Name: "db", FileName: "db.txt"}},
+			}
+
+			_, err := p.HandleMountRequest(context.Background(), params)
+			require.ErrorIs(t, err, d.err)
+		})
+	}
+}
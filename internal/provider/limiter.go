@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// limiter is a semaphore which bounds the number of concurrent upstream requests
+// and keeps track of the callers waiting for a free slot. A nil limiter is unlimited.
+type limiter struct {
+	slots   chan struct{}
+	waiting int64
+}
+
+func newLimiter(limit int) *limiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &limiter{slots: make(chan struct{}, limit)}
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	atomic.AddInt64(&l.waiting, 1)
+	defer atomic.AddInt64(&l.waiting, -1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// queueDepth returns the number of callers waiting for a free slot.
+func (l *limiter) queueDepth() int {
+	if l == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&l.waiting))
+}
+
+// active returns the number of callers holding a slot.
+func (l *limiter) active() int {
+	if l == nil {
+		return 0
+	}
+
+	return len(l.slots)
+}
@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2)
+	require.NoError(t, l.acquire(context.Background()))
+	require.NoError(t, l.acquire(context.Background()))
+	require.Equal(t, 2, l.active())
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+
+	require.Eventually(t, func() bool { return l.queueDepth() == 1 }, time.Second, time.Millisecond)
+
+	l.release()
+	require.NoError(t, <-acquired)
+	require.Equal(t, 0, l.queueDepth())
+	require.Equal(t, 2, l.active())
+}
+
+func TestLimiter_ContextCancelled(t *testing.T) {
+	l := newLimiter(1)
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+	require.Equal(t, 0, l.queueDepth())
+	require.Equal(t, 1, l.active())
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := newLimiter(0)
+	require.Nil(t, l)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.acquire(context.Background()))
+	}
+
+	l.release()
+	require.Equal(t, 0, l.queueDepth())
+}
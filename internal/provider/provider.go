@@ -6,95 +6,730 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/kloyan/credstore-csi-provider/internal/cache"
 	"github.com/kloyan/credstore-csi-provider/internal/client"
 	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/kloyan/credstore-csi-provider/internal/identity"
+	"github.com/kloyan/credstore-csi-provider/internal/policy"
+	"go.uber.org/zap"
 	pb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
+// DefaultServiceKey names the client used by SecretProviderClasses without a serviceKey parameter.
+const DefaultServiceKey = ""
+
+// ErrUnknownServiceKey is returned for mount requests which reference a service key
+// the provider was not configured with.
+var ErrUnknownServiceKey = errors.New("unknown service key")
+
+// ErrInvalidServiceKey is returned for service keys supplied by pods which cannot be used.
+var ErrInvalidServiceKey = errors.New("invalid service key")
+
 type Provider struct {
-	credStoreClient *client.Client
+	clientsMu      sync.RWMutex
+	clients        map[string]*client.Client
+	podClients     *clientCache
+	policy         atomic.Pointer[policy.Policy]
+	identity       *identity.Verifier
+	cache          *cache.Cache
+	flight         flightGroup
+	upstream       *limiter
+	fetchLimit     int
+	allowGenerate  bool
+	timeouts       Timeouts
+	pendingFetches int64
+	logger         *zap.SugaredLogger
+}
+
+type Options struct {
+	// Cache stores fetched credentials between mount requests, nil disables caching.
+	Cache *cache.Cache
+	// MaxConcurrentFetches bounds the credentials fetched in parallel for a single mount request.
+	MaxConcurrentFetches int
+	// MaxUpstreamRequests bounds the concurrent requests to Credential Store across all mount requests.
+	MaxUpstreamRequests int
+	// ClientFactory builds the clients for service keys supplied by pods, nil rejects such keys.
+	ClientFactory ClientFactory
+	// MaxPodClients bounds the number of cached clients built by ClientFactory, 0 means unlimited.
+	MaxPodClients int
+	// Policy restricts the credentials pods may read, nil allows every credential. Mounts
+	// with a service key supplied by the pod are checked as mounts with the service key "pod".
+	Policy *policy.Policy
+	// Identity verifies the service account token of every mount request. The verified
+	// namespace and service account then replace the pod attributes passed by the driver.
+	Identity *identity.Verifier
+	// AllowGenerate lets entries with generateIfMissing create missing credentials.
+	// Otherwise they fail like any other missing credential.
+	AllowGenerate bool
+	Timeouts      Timeouts
+	Logger        *zap.SugaredLogger
+}
+
+// Timeouts holds the provider-wide defaults and maximums of the timeouts which a
+// SecretProviderClass can set. Zero values mean no default and no maximum respectively.
+type Timeouts struct {
+	DefaultFetch time.Duration
+	MaxFetch     time.Duration
+	DefaultMount time.Duration
+	MaxMount     time.Duration
+}
+
+func (t Timeouts) fetch(requested time.Duration) time.Duration {
+	return effectiveTimeout(requested, t.DefaultFetch, t.MaxFetch)
+}
+
+func (t Timeouts) mount(requested time.Duration) time.Duration {
+	return effectiveTimeout(requested, t.DefaultMount, t.MaxMount)
+}
+
+func effectiveTimeout(requested, defaultTimeout, maxTimeout time.Duration) time.Duration {
+	timeout := requested
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	if maxTimeout > 0 && (timeout == 0 || timeout > maxTimeout) {
+		timeout = maxTimeout
+	}
+
+	return timeout
 }
 
-func NewProvider(credStoreClient *client.Client) *Provider {
-	return &Provider{
-		credStoreClient: credStoreClient,
+// withTimeout is context.WithTimeout which keeps the context unchanged for a zero timeout.
+// An earlier deadline of the parent, e.g. the one of the gRPC request, always wins.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Stats describes the load of the provider.
+type Stats struct {
+	// PendingFetches is the number of credentials waiting for a worker of their mount request.
+	PendingFetches int `json:"pendingFetches"`
+	// QueuedUpstreamRequests is the number of fetches waiting for the process-wide upstream limit.
+	QueuedUpstreamRequests int `json:"queuedUpstreamRequests"`
+	// ActiveUpstreamRequests is the number of requests to Credential Store in progress, if limited.
+	ActiveUpstreamRequests int `json:"activeUpstreamRequests"`
+	// PodClients is the number of cached clients built for service keys supplied by pods.
+	PodClients int `json:"podClients"`
+}
+
+// credentialRef identifies a single credential in Credential Store. Several
+// mounted files can reference the same credential, which is then fetched once.
+type credentialRef struct {
+	Namespace string
+	Type      string
+	Name      string
+}
+
+// CredentialError describes a credential entry which could not be mounted.
+type CredentialError struct {
+	Credential config.Credential
+	Err        error
+}
+
+func (e *CredentialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CredentialError) Unwrap() error {
+	return e.Err
+}
+
+// MountError lists every credential entry which failed a mount request.
+type MountError struct {
+	Failures []*CredentialError
+}
+
+func (e *MountError) Error() string {
+	return errors.Join(e.Unwrap()...).Error()
+}
+
+func (e *MountError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure
+	}
+
+	return errs
+}
+
+func NewProvider(credStoreClient *client.Client, opts Options) *Provider {
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+
+	p := &Provider{
+		clients:       make(map[string]*client.Client),
+		cache:         opts.Cache,
+		upstream:      newLimiter(opts.MaxUpstreamRequests),
+		fetchLimit:    opts.MaxConcurrentFetches,
+		allowGenerate: opts.AllowGenerate,
+		timeouts:      opts.Timeouts,
+		logger:        logger,
+	}
+	if credStoreClient != nil {
+		p.clients[DefaultServiceKey] = credStoreClient
+	}
+
+	if opts.ClientFactory != nil {
+		p.podClients = newClientCache(opts.ClientFactory, opts.MaxPodClients)
+	}
+
+	p.policy.Store(opts.Policy)
+	p.identity = opts.Identity
+
+	return p
+}
+
+// SetClient adds or replaces the Credential Store client of a named service key, e.g.
+// after the key was rotated. Mount requests in progress finish with the client they
+// started with, while the idle connections of the replaced client are closed.
+func (p *Provider) SetClient(serviceKey string, credStoreClient *client.Client) {
+	p.clientsMu.Lock()
+	replaced := p.clients[serviceKey]
+	p.clients[serviceKey] = credStoreClient
+	p.clientsMu.Unlock()
+
+	if replaced != nil && replaced != credStoreClient {
+		replaced.CloseIdleConnections()
+	}
+}
+
+// SetPolicy replaces the authorization policy, e.g. after the policy file changed.
+func (p *Provider) SetPolicy(policy *policy.Policy) {
+	p.policy.Store(policy)
+}
+
+// clientFor selects the client of a mount request. A service key supplied by the pod
+// takes precedence over the service keys of the provider.
+func (p *Provider) clientFor(params config.Parameters) (*client.Client, error) {
+	if params.PodServiceKey == nil {
+		return p.client(params.ServiceKey)
+	}
+
+	if p.podClients == nil {
+		return nil, fmt.Errorf("%w: service keys from nodePublishSecretRef are disabled", ErrInvalidServiceKey)
+	}
+
+	c, err := p.podClients.get(*params.PodServiceKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidServiceKey, err)
+	}
+
+	return c, nil
+}
+
+func (p *Provider) client(serviceKey string) (*client.Client, error) {
+	p.clientsMu.RLock()
+	defer p.clientsMu.RUnlock()
+
+	c, ok := p.clients[serviceKey]
+	if !ok {
+		if serviceKey == DefaultServiceKey {
+			return nil, fmt.Errorf("%w: no default service key configured, the serviceKey parameter is required", ErrUnknownServiceKey)
+		}
+
+		return nil, fmt.Errorf("%w %s", ErrUnknownServiceKey, serviceKey)
+	}
+
+	return c, nil
+}
+
+func (p *Provider) Stats() Stats {
+	stats := Stats{
+		PendingFetches:         int(atomic.LoadInt64(&p.pendingFetches)),
+		QueuedUpstreamRequests: p.upstream.queueDepth(),
+		ActiveUpstreamRequests: p.upstream.active(),
+	}
+
+	if p.podClients != nil {
+		stats.PodClients = p.podClients.len()
+	}
+
+	return stats
 }
 
 func (p *Provider) HandleMountRequest(ctx context.Context, params config.Parameters) (*pb.MountResponse, error) {
-	files := make([]*pb.File, len(params.Credentials))
-	versions := make([]*pb.ObjectVersion, len(params.Credentials))
-	errs := make([]error, len(params.Credentials))
+	ctx, cancel := withTimeout(ctx, p.timeouts.mount(params.MountTimeout))
+	defer cancel()
+
+	var err error
+	params.Pod, err = p.verifyIdentity(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.clientFor(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// selectors are authorized before they are listed and again after their expansion
+	if err := p.authorize(params, params.Credentials); err != nil {
+		return nil, err
+	}
+
+	fetchTimeout := p.timeouts.fetch(params.FetchTimeout)
+	creds, err := p.expandSelectors(ctx, c, params.Credentials, fetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.authorize(params, creds); err != nil {
+		return nil, err
+	}
+
+	fetched, err := p.fetchCredentials(ctx, c, creds, fetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*pb.File, len(creds))
+	versions := make([]*pb.ObjectVersion, len(creds))
+
+	for i, cred := range creds {
+		fields := fetched[refOf(cred)]
+		content := credentialContent(cred, fields)
+
+		mode := params.Permission
+		if cred.Mode != nil {
+			mode = *cred.Mode
+		}
+
+		files[i] = &pb.File{
+			Path:     cred.FileName,
+			Mode:     mode,
+			Contents: []byte(content),
+		}
+		versions[i] = generateVersion(cred, fields["id"], content)
+	}
+
+	return &pb.MountResponse{
+		Files:         files,
+		ObjectVersion: versions,
+	}, nil
+}
+
+// verifyIdentity returns the pod identity proven by its service account token. Without
+// a verifier the pod attributes passed by the driver are trusted.
+func (p *Provider) verifyIdentity(ctx context.Context, params config.Parameters) (config.Pod, error) {
+	if p.identity == nil {
+		return params.Pod, nil
+	}
+
+	id, err := p.identity.Verify(ctx, params.ServiceAccountTokens[p.identity.Audience()])
+	if err != nil {
+		return config.Pod{}, err
+	}
+
+	if (len(params.Pod.Namespace) != 0 && params.Pod.Namespace != id.Namespace) ||
+		(len(params.Pod.ServiceAccount) != 0 && params.Pod.ServiceAccount != id.ServiceAccount) {
+		return config.Pod{}, fmt.Errorf("%w: token of %s does not belong to pod %s/%s", identity.ErrInvalidToken, id.Subject, params.Pod.Namespace, params.Pod.Name)
+	}
+
+	pod := config.Pod{Namespace: id.Namespace, Name: id.PodName, ServiceAccount: id.ServiceAccount}
+	if len(pod.Name) == 0 {
+		pod.Name = params.Pod.Name
+	}
+
+	return pod, nil
+}
+
+// authorize checks the credential entries of a mount request against the policy and
+// audit logs every denied entry. Selectors only need access to their namespace.
+func (p *Provider) authorize(params config.Parameters, creds []config.Credential) error {
+	policy := p.policy.Load()
+	if policy == nil {
+		return nil
+	}
+
+	mountErr := &MountError{}
+	for _, cred := range creds {
+		req := policyRequest(params, cred)
+		if err := policy.Allow(req); err != nil {
+			p.logger.Warnw("mount request denied by policy",
+				"audit", true,
+				"podNamespace", req.PodNamespace,
+				"podName", req.PodName,
+				"serviceAccount", req.ServiceAccount,
+				"serviceKey", req.ServiceKey,
+				"namespace", req.Namespace,
+				"type", req.Type,
+				"name", req.Name,
+				"fileName", cred.FileName,
+			)
+			mountErr.Failures = append(mountErr.Failures, &CredentialError{Credential: cred, Err: err})
+		}
+	}
+
+	if len(mountErr.Failures) != 0 {
+		return mountErr
+	}
+
+	return nil
+}
+
+func policyRequest(params config.Parameters, cred config.Credential) policy.Request {
+	req := policy.Request{
+		PodNamespace:   params.Pod.Namespace,
+		PodName:        params.Pod.Name,
+		ServiceAccount: params.Pod.ServiceAccount,
+		ServiceKey:     params.ServiceKey,
+		Namespace:      cred.Namespace,
+		Type:           cred.Type,
+	}
+
+	switch {
+	case params.PodServiceKey != nil:
+		req.ServiceKey = policy.PodServiceKey
+	case params.ServiceKey == DefaultServiceKey:
+		req.ServiceKey = policy.DefaultServiceKey
+	}
+
+	if !cred.IsSelector() {
+		req.Name = cred.Name
+	}
+
+	return req
+}
+
+// expandSelectors replaces selector entries with the matching credentials of their
+// namespace. Each namespace and type is listed once per mount request.
+func (p *Provider) expandSelectors(ctx context.Context, c *client.Client, creds []config.Credential, fetchTimeout time.Duration) ([]config.Credential, error) {
+	type listing struct {
+		names []string
+		err   error
+	}
+
+	listings := make(map[credentialRef]listing)
+	mountErr := &MountError{}
+	var expanded []config.Credential
+
+	for _, cred := range creds {
+		if !cred.IsSelector() {
+			expanded = append(expanded, cred)
+			continue
+		}
+
+		ref := credentialRef{Namespace: cred.Namespace, Type: cred.Type}
+		l, ok := listings[ref]
+		if !ok {
+			l.names, l.err = p.listCredentials(ctx, c, ref, fetchTimeout)
+			listings[ref] = l
+		}
+
+		if l.err != nil {
+			mountErr.Failures = append(mountErr.Failures, &CredentialError{Credential: cred, Err: l.err})
+			continue
+		}
+
+		matches, err := config.ExpandSelector(cred, l.names)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", config.ErrInvalidCredentials, err)
+		}
+
+		expanded = append(expanded, matches...)
+	}
+
+	if len(mountErr.Failures) != 0 {
+		return nil, mountErr
+	}
+
+	if err := config.ValidateCredentials(expanded); err != nil {
+		return nil, fmt.Errorf("%w: %v", config.ErrInvalidCredentials, err)
+	}
+
+	return expanded, nil
+}
+
+// listCredentials lists the credentials of a namespace and type. Listings are cached,
+// coalesced and served stale like credentials, under the key of the namespace and type
+// with an empty name, and the names are stored as the keys of the fields.
+func (p *Provider) listCredentials(ctx context.Context, c *client.Client, ref credentialRef, fetchTimeout time.Duration) ([]string, error) {
+	key := cache.Key{
+		ServiceKey: c.Fingerprint,
+		Namespace:  ref.Namespace,
+		Type:       ref.Type,
+	}
+
+	listed, err := p.cachedFetch(ctx, key, func(ctx context.Context) (map[string]string, error) {
+		ctx, cancel := withTimeout(ctx, fetchTimeout)
+		defer cancel()
+
+		return p.flight.do(ctx, key, func(ctx context.Context) (map[string]string, error) {
+			if err := p.upstream.acquire(ctx); err != nil {
+				return nil, fmt.Errorf("could not list %ss in %s: waiting for upstream request slot: %w", ref.Type, ref.Namespace, err)
+			}
+			defer p.upstream.release()
+
+			names, err := c.ListCredentials(ctx, ref.Type, ref.Namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			listed := make(map[string]string, len(names))
+			for _, name := range names {
+				listed[name] = ""
+			}
+
+			return listed, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(listed))
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// fetchCredentials concurrently fetches every distinct credential referenced by creds
+// using at most fetchLimit workers. Each fetch, including its retries, is bounded by fetchTimeout.
+func (p *Provider) fetchCredentials(ctx context.Context, c *client.Client, creds []config.Credential, fetchTimeout time.Duration) (map[credentialRef]map[string]string, error) {
+	var refs []credentialRef
+	seen := make(map[credentialRef]bool)
+	generate := make(map[credentialRef]*config.Generate)
+	for _, cred := range creds {
+		ref := refOf(cred)
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+
+		if cred.GenerateIfMissing != nil && generate[ref] == nil {
+			generate[ref] = cred.GenerateIfMissing
+		}
+	}
+
+	workers := len(refs)
+	if p.fetchLimit > 0 && p.fetchLimit < workers {
+		workers = p.fetchLimit
+	}
+
+	fields := make([]map[string]string, len(refs))
+	errs := make(map[credentialRef]error)
+	errsMu := sync.Mutex{}
+	jobs := make(chan int, len(refs))
 	wg := sync.WaitGroup{}
 
-	for i, cred := range params.Credentials {
+	atomic.AddInt64(&p.pendingFetches, int64(len(refs)))
+	for i := range refs {
+		jobs <- i
+	}
+	close(jobs)
+
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
 
-		go func(i int, cred config.Credential) {
+		go func() {
 			defer wg.Done()
 
-			content, err := p.getCredentialContent(ctx, cred)
-			if err != nil {
-				errs[i] = err
-				return
+			for i := range jobs {
+				atomic.AddInt64(&p.pendingFetches, -1)
+
+				var err error
+				fields[i], err = p.getCachedCredentialFields(ctx, c, refs[i], generate[refs[i]], fetchTimeout)
+				if err != nil {
+					errsMu.Lock()
+					errs[refs[i]] = err
+					errsMu.Unlock()
+				}
 			}
+		}()
+	}
+
+	wg.Wait()
 
-			mode := params.Permission
-			if cred.Mode != nil {
-				mode = *cred.Mode
+	if len(errs) != 0 {
+		mountErr := &MountError{}
+		for _, cred := range creds {
+			if err, ok := errs[refOf(cred)]; ok {
+				mountErr.Failures = append(mountErr.Failures, &CredentialError{Credential: cred, Err: err})
 			}
+		}
+
+		return nil, mountErr
+	}
+
+	fetched := make(map[credentialRef]map[string]string, len(refs))
+	for i, ref := range refs {
+		fetched[ref] = fields[i]
+	}
+
+	return fetched, nil
+}
 
-			files[i] = &pb.File{
-				Path:     cred.FileName,
-				Mode:     mode,
-				Contents: []byte(content),
+// getCachedCredentialFields fetches a credential through the cache. If gen is set and
+// generating is allowed, a missing credential is created with a generated value. Only
+// the lookup is shared with concurrent fetches of the same credential, so that a caller
+// without gen cannot make another one fail with ErrNotFound or vice versa.
+func (p *Provider) getCachedCredentialFields(ctx context.Context, c *client.Client, ref credentialRef, gen *config.Generate, fetchTimeout time.Duration) (map[string]string, error) {
+	key := cache.Key{
+		ServiceKey: c.Fingerprint,
+		Namespace:  ref.Namespace,
+		Type:       ref.Type,
+		Name:       ref.Name,
+	}
+
+	fetch := func(ctx context.Context) (map[string]string, error) {
+		ctx, cancel := withTimeout(ctx, fetchTimeout)
+		defer cancel()
+
+		fields, err := p.flight.do(ctx, key, func(ctx context.Context) (map[string]string, error) {
+			if err := p.upstream.acquire(ctx); err != nil {
+				return nil, fmt.Errorf("could not get %s %s/%s: waiting for upstream request slot: %w", ref.Type, ref.Namespace, ref.Name, err)
 			}
-			versions[i] = generateVersion(cred, content)
-		}(i, cred)
+			defer p.upstream.release()
+
+			return getCredentialFields(ctx, c, ref)
+		})
+		if gen == nil || !errors.Is(err, client.ErrNotFound) {
+			return fields, err
+		}
+
+		if !p.allowGenerate {
+			return nil, fmt.Errorf("%w, generateIfMissing is not allowed by the provider", err)
+		}
+
+		if err := p.upstream.acquire(ctx); err != nil {
+			return nil, fmt.Errorf("could not create %s %s/%s: waiting for upstream request slot: %w", ref.Type, ref.Namespace, ref.Name, err)
+		}
+		defer p.upstream.release()
+
+		return p.createCredential(ctx, c, ref, *gen)
 	}
 
-	wg.Wait()
+	return p.cachedFetch(ctx, key, fetch)
+}
 
-	if err := errors.Join(errs...); err != nil {
-		return nil, err
+// cachedFetch calls fetch through the cache, if any, and serves a stale cached value
+// if fetch failed because of an outage.
+func (p *Provider) cachedFetch(ctx context.Context, key cache.Key, fetch cache.FetchFunc) (map[string]string, error) {
+	if p.cache == nil {
+		return fetch(ctx)
 	}
 
-	return &pb.MountResponse{
-		Files:         files,
-		ObjectVersion: versions,
-	}, nil
+	fields, stale, err := p.cache.Get(ctx, key, fetch)
+	if stale && servesStale(err) {
+		p.logger.Warnw("serving stale credential, credstore request failed",
+			"namespace", key.Namespace,
+			"type", key.Type,
+			"name", key.Name,
+			"err", err,
+		)
+		return fields, nil
+	}
+
+	return fields, err
 }
 
-func (p *Provider) getCredentialContent(ctx context.Context, cred config.Credential) (string, error) {
-	if cred.Type == "password" {
-		pass, err := p.credStoreClient.GetPassword(ctx, cred.Namespace, cred.Name)
+// servesStale reports whether a failed fetch may be answered with a stale cached value.
+// Only outages qualify. A deleted credential, a revoked binding or a response which
+// cannot be decrypted or verified must fail the mount instead.
+func servesStale(err error) bool {
+	for _, outage := range []error{client.ErrUnavailable, client.ErrRateLimited, client.ErrCircuitOpen, context.DeadlineExceeded} {
+		if errors.Is(err, outage) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getCredentialFields(ctx context.Context, c *client.Client, ref credentialRef) (map[string]string, error) {
+	switch ref.Type {
+	case "password":
+		pass, err := c.GetPassword(ctx, ref.Namespace, ref.Name)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		return pass.Value, nil
-	}
+		return pass.Fields(), nil
+	case "key":
+		key, err := c.GetKey(ctx, ref.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
 
-	if cred.Type == "key" {
-		key, err := p.credStoreClient.GetKey(ctx, cred.Namespace, cred.Name)
+		return key.Fields(), nil
+	case "certificate":
+		cert, err := c.GetCertificate(ctx, ref.Namespace, ref.Name)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		return key.Value, nil
+		return cert.Fields(), nil
 	}
 
-	return "", fmt.Errorf("invalid credential type %s", cred.Type)
+	return nil, fmt.Errorf("invalid credential type %s", ref.Type)
+}
+
+func refOf(cred config.Credential) credentialRef {
+	return credentialRef{Namespace: cred.Namespace, Type: cred.Type, Name: cred.Name}
 }
 
-func generateVersion(cred config.Credential, content string) *pb.ObjectVersion {
+// credentialContent selects the mounted field of a credential. Without an explicit
+// field the value is mounted, except for certificates which are mounted as a PEM bundle.
+func credentialContent(cred config.Credential, fields map[string]string) string {
+	if len(cred.Field) != 0 {
+		return fields[cred.Field]
+	}
+
+	if cred.Type == "certificate" {
+		return pemBundle(fields["value"], fields["chain"], fields["key"])
+	}
+
+	return fields["value"]
+}
+
+// pemBundle concatenates the non-empty PEM blocks, making sure each one ends with a newline.
+func pemBundle(blocks ...string) string {
+	var sb strings.Builder
+	for _, block := range blocks {
+		if len(block) == 0 {
+			continue
+		}
+
+		sb.WriteString(block)
+		if !strings.HasSuffix(block, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// generateVersion identifies the mounted revision of a file entry. The version changes
+// only when the mounted content changes or the credential is recreated upstream, so it
+// stays stable across mount requests and provider restarts. modifiedAt is left out on
+// purpose, it also changes on metadata-only updates, and so is the service key, whose
+// rotation must not make the driver rewrite every file. The upstream id is part of the
+// digest, so equal values of different credentials do not share a version.
+func generateVersion(cred config.Credential, upstreamID, content string) *pb.ObjectVersion {
 	hash := sha256.New()
-	hash.Write([]byte(fmt.Sprintf("%v:%s", cred, content)))
+	for _, part := range []string{upstreamID, cred.Namespace, cred.Type, cred.Name, content} {
+		// length prefixes keep the boundaries between the parts unambiguous
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
 
 	return &pb.ObjectVersion{
-		Id:      fmt.Sprintf("%s/%s/%s", cred.Namespace, cred.Type, cred.Name),
+		Id:      fmt.Sprintf("%s/%s/%s/%s", cred.Namespace, cred.Type, cred.Name, cred.FileName),
 		Version: base64.URLEncoding.EncodeToString(hash.Sum(nil)),
 	}
 }
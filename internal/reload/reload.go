@@ -0,0 +1,163 @@
+package reload
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ApplyFunc validates and applies new file contents. Contents which are rejected
+// leave the previously applied contents in use.
+type ApplyFunc func(contents []byte) error
+
+// Watcher polls a file and applies its contents whenever they change. Polling the
+// contents rather than watching inodes also covers Kubernetes volumes, which swap
+// a ..data symlink atomically instead of writing to the file.
+type Watcher struct {
+	path     string
+	read     func() ([]byte, error)
+	interval time.Duration
+	apply    ApplyFunc
+	logger   *zap.SugaredLogger
+	trigger  chan struct{}
+	seen     [sha256.Size]byte
+}
+
+// NewWatcher creates a watcher for the file at path. The contents the process started
+// with are passed as initial so that they are not applied a second time. An interval
+// of 0 disables polling, reloads then only happen through Reload.
+func NewWatcher(path string, initial []byte, interval time.Duration, apply ApplyFunc, logger *zap.SugaredLogger) *Watcher {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+
+	return &Watcher{
+		path:     path,
+		read:     func() ([]byte, error) { return os.ReadFile(path) },
+		interval: interval,
+		apply:    apply,
+		logger:   logger,
+		trigger:  make(chan struct{}, 1),
+		seen:     sha256.Sum256(initial),
+	}
+}
+
+// NewDirWatcher creates a watcher for the files in dir, which applies whenever a file
+// is added, removed or changed. Instead of file contents, apply receives a digest of
+// the directory and is expected to read the files itself.
+func NewDirWatcher(dir string, interval time.Duration, apply ApplyFunc, logger *zap.SugaredLogger) (*Watcher, error) {
+	initial, err := dirDigest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := NewWatcher(dir, initial, interval, apply, logger)
+	w.read = func() ([]byte, error) { return dirDigest(dir) }
+
+	return w, nil
+}
+
+// ListFiles returns the names of the regular files in dir, following symlinks and
+// skipping the ..data and timestamped directories of Kubernetes secret volumes.
+func ListFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if info, err := os.Stat(filepath.Join(dir, entry.Name())); err != nil || info.IsDir() {
+			continue
+		}
+
+		files = append(files, entry.Name())
+	}
+
+	return files, nil
+}
+
+// dirDigest lists the name and content hash of every file in dir.
+func dirDigest(dir string) ([]byte, error) {
+	files, err := ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	var digest strings.Builder
+	for _, name := range files {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&digest, "%s %x\n", name, sha256.Sum256(contents))
+	}
+
+	return []byte(digest.String()), nil
+}
+
+// Reload makes Run re-read and apply the file even if its contents did not change.
+func (w *Watcher) Reload() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls the file until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			w.check(false)
+		case <-w.trigger:
+			w.check(true)
+		}
+	}
+}
+
+// check applies the file if it changed since it was last seen. Contents which were
+// rejected are not retried until they change again or a reload is forced.
+func (w *Watcher) check(force bool) {
+	contents, err := w.read()
+	if err != nil {
+		w.logger.Errorw("could not read watched file, keeping previous contents", "path", w.path, "err", err)
+		return
+	}
+
+	hash := sha256.Sum256(contents)
+	if hash == w.seen && !force {
+		return
+	}
+
+	w.seen = hash
+	if err := w.apply(contents); err != nil {
+		w.logger.Errorw("rejected watched file, keeping previous contents", "path", w.path, "err", err)
+		return
+	}
+
+	w.logger.Infow("reloaded watched file", "path", w.path)
+}
@@ -0,0 +1,147 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeAtomic mimics the kubelet atomic writer: the file is a symlink into ..data,
+// which is itself a symlink swapped to a new timestamped directory on every update.
+func writeAtomic(t *testing.T, dir, name, contents string) {
+	t.Helper()
+
+	tsDir, err := os.MkdirTemp(dir, "..ts_")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tsDir, name), []byte(contents), 0o600))
+
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	require.NoError(t, os.Symlink(filepath.Base(tsDir), tmpLink))
+	require.NoError(t, os.Rename(tmpLink, filepath.Join(dir, "..data")))
+
+	link := filepath.Join(dir, name)
+	if _, err := os.Lstat(link); os.IsNotExist(err) {
+		require.NoError(t, os.Symlink(filepath.Join("..data", name), link))
+	}
+}
+
+func startWatcher(t *testing.T, path, initial string, apply ApplyFunc) *Watcher {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w := NewWatcher(path, []byte(initial), 10*time.Millisecond, apply, nil)
+	go w.Run(ctx)
+
+	return w
+}
+
+func receive(t *testing.T, applied <-chan string) string {
+	t.Helper()
+
+	select {
+	case contents := <-applied:
+		return contents
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "file was not applied")
+		return ""
+	}
+}
+
+func TestWatcher_SymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "service-key.json")
+	writeAtomic(t, dir, "service-key.json", "v1")
+
+	applied := make(chan string, 10)
+	startWatcher(t, path, "v1", func(contents []byte) error {
+		applied <- string(contents)
+		return nil
+	})
+
+	writeAtomic(t, dir, "service-key.json", "v2")
+	require.Equal(t, "v2", receive(t, applied))
+
+	writeAtomic(t, dir, "service-key.json", "v3")
+	require.Equal(t, "v3", receive(t, applied))
+}
+
+func TestWatcher_Rejected(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "service-key.json")
+	writeAtomic(t, dir, "service-key.json", "v1")
+
+	applied := make(chan string, 10)
+	startWatcher(t, path, "v1", func(contents []byte) error {
+		applied <- string(contents)
+		if string(contents) == "invalid" {
+			return errors.New("invalid service key")
+		}
+
+		return nil
+	})
+
+	writeAtomic(t, dir, "service-key.json", "invalid")
+	require.Equal(t, "invalid", receive(t, applied))
+
+	// rejected contents are not retried on every poll
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, applied)
+
+	writeAtomic(t, dir, "service-key.json", "v2")
+	require.Equal(t, "v2", receive(t, applied))
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service-key.json")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0o600))
+
+	applied := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w := NewWatcher(path, []byte("v1"), 0, func(contents []byte) error {
+		applied <- string(contents)
+		return nil
+	}, nil)
+	go w.Run(ctx)
+
+	w.Reload()
+	require.Equal(t, "v1", receive(t, applied))
+}
+
+func TestDirWatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeAtomic(t, dir, "team-a.json", "a1")
+
+	applied := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	w, err := NewDirWatcher(dir, 10*time.Millisecond, func(contents []byte) error {
+		applied <- string(contents)
+		return nil
+	}, nil)
+	require.NoError(t, err)
+	go w.Run(ctx)
+
+	// added files are detected besides changed ones
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-b.json"), []byte("b1"), 0o600))
+	require.Contains(t, receive(t, applied), "team-b.json")
+
+	writeAtomic(t, dir, "team-a.json", "a2")
+	receive(t, applied)
+
+	files, err := ListFiles(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"team-a.json", "team-b.json"}, files)
+
+	_, err = NewDirWatcher(filepath.Join(dir, "missing"), 0, nil, nil)
+	require.Error(t, err)
+}
@@ -2,14 +2,21 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 
+	"github.com/kloyan/credstore-csi-provider/internal/client"
 	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/kloyan/credstore-csi-provider/internal/identity"
+	"github.com/kloyan/credstore-csi-provider/internal/policy"
 	"github.com/kloyan/credstore-csi-provider/internal/provider"
 	"github.com/kloyan/credstore-csi-provider/internal/version"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	pb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -62,10 +69,117 @@ func (s *Server) Version(ctx context.Context, req *pb.VersionRequest) (*pb.Versi
 func (s *Server) Mount(ctx context.Context, req *pb.MountRequest) (*pb.MountResponse, error) {
 	params, err := config.ParseParameters(req.Attributes, req.Permission)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	return s.provider.HandleMountRequest(ctx, params)
+	params.PodServiceKey, err = config.ParseSecrets(req.Secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if params.PodServiceKey != nil && len(params.ServiceKey) != 0 {
+		return nil, status.Error(codes.InvalidArgument, "serviceKey parameter cannot be combined with a service key from nodePublishSecretRef")
+	}
+
+	resp, err := s.provider.HandleMountRequest(ctx, params)
+	if err != nil {
+		return nil, mountStatus(err)
+	}
+
+	return resp, nil
+}
+
+// errorDomain identifies the provider in the ErrorInfo details of failed mount requests.
+const errorDomain = "credstore.csi.provider"
+
+// errorCategories maps mount errors to gRPC codes and error reasons, so that the driver
+// can tell missing credentials apart from an unavailable Credential Store. Earlier
+// entries take precedence when a mount request fails for several reasons.
+var errorCategories = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{config.ErrInvalidCredentials, codes.InvalidArgument, "INVALID_CREDENTIALS"},
+	{provider.ErrUnknownServiceKey, codes.InvalidArgument, "UNKNOWN_SERVICE_KEY"},
+	{provider.ErrInvalidServiceKey, codes.InvalidArgument, "INVALID_SERVICE_KEY"},
+	{identity.ErrInvalidToken, codes.Unauthenticated, "INVALID_TOKEN"},
+	{policy.ErrDenied, codes.PermissionDenied, "POLICY_DENIED"},
+	{client.ErrNotFound, codes.NotFound, "CREDENTIAL_NOT_FOUND"},
+	{client.ErrConflict, codes.Aborted, "CREDENTIAL_CONFLICT"},
+	{client.ErrUnauthorized, codes.Unauthenticated, "UNAUTHORIZED"},
+	{client.ErrForbidden, codes.PermissionDenied, "FORBIDDEN"},
+	{client.ErrRateLimited, codes.ResourceExhausted, "RATE_LIMITED"},
+	{client.ErrCircuitOpen, codes.Unavailable, "CIRCUIT_OPEN"},
+	{client.ErrUnavailable, codes.Unavailable, "CREDSTORE_UNAVAILABLE"},
+	{client.ErrDecryptionFailed, codes.Internal, "DECRYPTION_FAILED"},
+	{client.ErrInvalidSignature, codes.Internal, "INVALID_SIGNATURE"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+	{context.Canceled, codes.Canceled, "CANCELED"},
+}
+
+func categorize(err error) (codes.Code, string) {
+	for _, category := range errorCategories {
+		if errors.Is(err, category.err) {
+			return category.code, category.reason
+		}
+	}
+
+	return codes.Unknown, "UNKNOWN"
+}
+
+// mountStatus converts a failed mount into a gRPC status which carries an ErrorInfo
+// detail for every credential entry that could not be mounted.
+func mountStatus(err error) error {
+	code, _ := categorize(err)
+	st := status.New(code, err.Error())
+
+	var mountErr *provider.MountError
+	if !errors.As(err, &mountErr) {
+		return st.Err()
+	}
+
+	for _, failure := range mountErr.Failures {
+		_, reason := categorize(failure.Err)
+		withDetails, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+			Reason: reason,
+			Domain: errorDomain,
+			Metadata: map[string]string{
+				"fileName":  failure.Credential.FileName,
+				"namespace": failure.Credential.Namespace,
+				"type":      failure.Credential.Type,
+				"name":      failure.Credential.Name,
+				"error":     failure.Err.Error(),
+			},
+		})
+		if detailsErr != nil {
+			return status.New(code, err.Error()).Err()
+		}
+
+		st = withDetails
+	}
+
+	return st.Err()
+}
+
+// MountFailures extracts the failed credential entries from the details of a mount status.
+func MountFailures(err error) []map[string]string {
+	var failures []map[string]string
+	for _, detail := range status.Convert(err).Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+
+		failure := map[string]string{"reason": info.Reason}
+		for k, v := range info.Metadata {
+			failure[k] = v
+		}
+
+		failures = append(failures, failure)
+	}
+
+	return failures
 }
 
 func listen(socketPath string) (net.Listener, error) {
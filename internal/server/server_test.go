@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kloyan/credstore-csi-provider/internal/client"
+	"github.com/kloyan/credstore-csi-provider/internal/config"
+	"github.com/kloyan/credstore-csi-provider/internal/identity"
+	"github.com/kloyan/credstore-csi-provider/internal/policy"
+	"github.com/kloyan/credstore-csi-provider/internal/provider"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+func TestCategorize(t *testing.T) {
+	data := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{"invalid credentials", config.ErrInvalidCredentials, codes.InvalidArgument, "INVALID_CREDENTIALS"},
+		{"unknown service key", fmt.Errorf("%w team-b", provider.ErrUnknownServiceKey), codes.InvalidArgument, "UNKNOWN_SERVICE_KEY"},
+		{"invalid service key", fmt.Errorf("%w: service keys from nodePublishSecretRef are disabled", provider.ErrInvalidServiceKey), codes.InvalidArgument, "INVALID_SERVICE_KEY"},
+		{"invalid token", fmt.Errorf("%w: no token for audience", identity.ErrInvalidToken), codes.Unauthenticated, "INVALID_TOKEN"},
+		{"policy denied", fmt.Errorf("%w: pod team-a/app may not read password team-b/db", policy.ErrDenied), codes.PermissionDenied, "POLICY_DENIED"},
+		{"not found", client.ErrNotFound, codes.NotFound, "CREDENTIAL_NOT_FOUND"},
+		{"conflict", client.ErrConflict, codes.Aborted, "CREDENTIAL_CONFLICT"},
+		{"unauthorized", client.ErrUnauthorized, codes.Unauthenticated, "UNAUTHORIZED"},
+		{"forbidden", client.ErrForbidden, codes.PermissionDenied, "FORBIDDEN"},
+		{"rate limited", client.ErrRateLimited, codes.ResourceExhausted, "RATE_LIMITED"},
+		{"circuit open", client.ErrCircuitOpen, codes.Unavailable, "CIRCUIT_OPEN"},
+		{"unavailable", client.ErrUnavailable, codes.Unavailable, "CREDSTORE_UNAVAILABLE"},
+		{"decryption failed", client.ErrDecryptionFailed, codes.Internal, "DECRYPTION_FAILED"},
+		{"invalid signature", client.ErrInvalidSignature, codes.Internal, "INVALID_SIGNATURE"},
+		{"deadline exceeded", context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+		{"wrapped", fmt.Errorf("could not get password dev/foo: %w", client.ErrNotFound), codes.NotFound, "CREDENTIAL_NOT_FOUND"},
+		{"joined", errors.Join(errors.New("foo"), client.ErrUnavailable), codes.Unavailable, "CREDSTORE_UNAVAILABLE"},
+		{"unknown", errors.New("foo"), codes.Unknown, "UNKNOWN"},
+	}
+
+	for _, d := range data {
+		code, reason := categorize(d.err)
+		require.Equal(t, d.code, code, d.name)
+		require.Equal(t, d.reason, reason, d.name)
+	}
+}
+
+func TestMountStatus(t *testing.T) {
+	mountErr := &provider.MountError{
+		Failures: []*provider.CredentialError{
+			{
+				Credential: config.Credential{Namespace: "dev", Type: "password", Name: "missing", FileName: "missing.txt"},
+				Err:        fmt.Errorf("could not get password dev/missing from credstore: %w", client.ErrNotFound),
+			},
+			{
+				Credential: config.Credential{Namespace: "dev", Type: "key", Name: "myKey", FileName: "key.der"},
+				Err:        fmt.Errorf("could not get key dev/myKey from credstore: %w", client.ErrUnavailable),
+			},
+		},
+	}
+
+	err := mountStatus(mountErr)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, mountErr.Error(), status.Convert(err).Message())
+	require.Equal(t, []map[string]string{
+		{
+			"reason":    "CREDENTIAL_NOT_FOUND",
+			"fileName":  "missing.txt",
+			"namespace": "dev",
+			"type":      "password",
+			"name":      "missing",
+			"error":     "could not get password dev/missing from credstore: credential not found",
+		},
+		{
+			"reason":    "CREDSTORE_UNAVAILABLE",
+			"fileName":  "key.der",
+			"namespace": "dev",
+			"type":      "key",
+			"name":      "myKey",
+			"error":     "could not get key dev/myKey from credstore: credstore unavailable",
+		},
+	}, MountFailures(err))
+}
+
+func TestMountStatus_WithoutFailures(t *testing.T) {
+	err := mountStatus(context.DeadlineExceeded)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Nil(t, MountFailures(err))
+}
+
+func TestMount_InvalidParameters(t *testing.T) {
+	s := &Server{}
+
+	resp, err := s.Mount(context.Background(), &pb.MountRequest{Attributes: "{}", Permission: "foo"})
+	require.Nil(t, resp)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "could not parse permission field")
+}
+
+func TestMount_ServiceKeyConflict(t *testing.T) {
+	s := &Server{}
+
+	secrets := `{"service-key.json":"{\"url\":\"https://credstore\",\"certificate\":\"cert\",\"key\":\"key\",\"encryption\":{\"client_private_key\":\"priv\"}}"}`
+	resp, err := s.Mount(context.Background(), &pb.MountRequest{
+		Attributes: `{"serviceKey":"team-a"}`,
+		Secrets:    secrets,
+		Permission: "420",
+	})
+	require.Nil(t, resp)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "cannot be combined")
+}